@@ -1,36 +1,283 @@
 package smartq
 
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
 type Admin struct {
 	r *repo
 }
 
+type PauseOptions struct {
+	Reason string
+	By     string
+	// ResumeAt resumes the channel automatically once passed; zero means never.
+	ResumeAt time.Time
+}
+
+type PurgeOptions struct {
+	// DeleteJobs also removes the sq_job_<id> hashes and their store copies.
+	DeleteJobs bool
+}
+
+const (
+	JobQueued    = "queued"
+	JobScheduled = "scheduled"
+	JobWorking   = "working"
+	JobIdle      = "idle"
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+type JobInfo struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	Created time.Time `json:"created"`
+	// State is one of JobQueued, JobScheduled, JobWorking or JobIdle.
+	State  string `json:"state"`
+	Fields Job    `json:"fields"`
+}
+
+type JobPage struct {
+	IDs    []string `json:"ids"`
+	Cursor uint64   `json:"cursor"`
+	// Total is only known for working set pages.
+	Total int64 `json:"total,omitempty"`
+}
+
+type WatcherInfo struct {
+	Name    string `json:"name"`
+	Channel string `json:"channel"`
+}
+
 func NewAdmin() *Admin {
 	return &Admin{
 		r: newrepo(),
 	}
 }
 
-// func (a *Admin) run(cmd string) (string, error) {
-// 	splitted := strings.Split(cmd, "|")
-// 	var id, command, channel string
-// 	if len(splitted) > 0 {
-// 		command = splitted[0]
-// 	}
+// NewAdminWithOptions is NewAdmin using opts instead of LoadConfig.
+func NewAdminWithOptions(opts Options) (*Admin, error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Admin{r: r}, nil
+}
+
+// PauseChannel stops watchers from taking jobs off channel. Jobs can still be
+// enqueued and routed to it. A channel that does not exist yet is registered,
+// so it can be paused before its first job.
+func (a *Admin) PauseChannel(channel string, opts PauseOptions) error {
+	if err := checkchannel(channel); err != nil {
+		return err
+	}
+
+	return a.r.setchannelpaused(channel, opts.Reason, opts.By, opts.ResumeAt)
+}
+
+// ResumeChannel clears both the paused and the draining state of channel.
+func (a *Admin) ResumeChannel(channel string) error {
+	if err := checkchannel(channel); err != nil {
+		return err
+	}
+
+	return a.r.setchannelresumed(channel)
+}
+
+// PurgeChannel empties the queue of channel and returns the number of jobs removed.
+// Jobs in the working set are left alone.
+func (a *Admin) PurgeChannel(channel string, opts PurgeOptions) (int, error) {
+	if err := checkchannel(channel); err != nil {
+		return 0, err
+	}
+
+	return a.r.purgechannel(channel, opts.DeleteJobs)
+}
+
+// DeleteChannel purges channel and its working set, then removes its keys
+// and its entry in sq_channels.
+func (a *Admin) DeleteChannel(channel string, opts PurgeOptions) (int, error) {
+	if err := checkchannel(channel); err != nil {
+		return 0, err
+	}
+
+	return a.r.deletechannel(channel, opts.DeleteJobs)
+}
+
+// DrainChannel stops channel from accepting new jobs and waits until watchers
+// have finished the queued and in-flight ones. ResumeChannel opens it again.
+//
+// Only enqueues are refused: jobs routed from another channel are still
+// accepted, so work already in a pipeline is not stranded in the working set
+// of the channel before it. The drain completes once the channel has no
+// queued, scheduled or in-flight jobs at the same moment; while upstream
+// channels keep routing into it that may never happen, so drain the
+// pipeline from its first channel on, or bound ctx.
+func (a *Admin) DrainChannel(ctx context.Context, channel string) error {
+	if err := checkchannel(channel); err != nil {
+		return err
+	}
+
+	if err := a.r.setchanneldraining(channel); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		pending, err := a.r.pendingcount(channel)
+		if err != nil {
+			return err
+		}
+
+		if pending == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ListChannels returns every channel registered in sq_channels.
+func (a *Admin) ListChannels() ([]string, error) {
+	var channels []string
+	err := a.r.zscan(a.r.keys.channels(), func(channel string) error {
+		channels = append(channels, channel)
+		return nil
+	})
+	return channels, err
+}
+
+// GetJob loads job id and reports whether it is queued or being worked on.
+func (a *Admin) GetJob(id string) (*JobInfo, error) {
+	return a.r.jobinfo(id)
+}
+
+// ListJobs returns a page of the jobs queued on channel. Pass the returned
+// cursor to get the next page; a zero cursor means there are no more pages.
+// count is a hint, as with ZSCAN.
+func (a *Admin) ListJobs(channel string, cursor uint64, count int) (*JobPage, error) {
+	ids, next, err := a.r.zscanpage(a.r.keys.channel(channel), cursor, int64(count))
+	if err != nil {
+		return nil, err
+	}
+
+	return &JobPage{IDs: ids, Cursor: next}, nil
+}
+
+// ListWorkingSet returns up to count jobs from the working set of channel,
+// starting at offset.
+func (a *Admin) ListWorkingSet(channel string, offset, count int) (*JobPage, error) {
+	if count <= 0 {
+		return nil, errors.New("count must be positive")
+	}
+
+	ids, total, err := a.r.listrange(a.r.keys.workingset(channel), int64(offset), int64(count))
+	if err != nil {
+		return nil, err
+	}
+
+	page := &JobPage{IDs: ids, Total: total}
+	if next := int64(offset + len(ids)); next < total {
+		page.Cursor = uint64(next)
+	}
+
+	return page, nil
+}
+
+// RequeueJob puts a job from the working set of channel back on the channel.
+func (a *Admin) RequeueJob(channel, id string) error {
+	moved, err := a.r.requeue(channel, id)
+	if err != nil {
+		return err
+	}
+
+	if moved == 0 {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// RequeueWorkingSet puts every job left in the working set of channel back on
+// the channel, e.g. after a watcher crashed. It returns the number of jobs moved.
+func (a *Admin) RequeueWorkingSet(channel string) (int, error) {
+	ids, _, err := a.r.listrange(a.r.keys.workingset(channel), 0, -1)
+	if err != nil {
+		return 0, err
+	}
+
+	return a.r.requeue(channel, ids...)
+}
+
+// MoveJob takes job id off channel from, whether queued, scheduled or in flight, and queues it on to.
+func (a *Admin) MoveJob(id, from, to string) error {
+	if err := checkchannel(to); err != nil {
+		return err
+	}
+
+	moved, err := a.r.movejob(id, from, to)
+	if err != nil {
+		return err
+	}
+
+	if !moved {
+		return ErrJobNotFound
+	}
+
+	return nil
+}
+
+// DeleteJob removes job id from its channel and deletes its data, including the store copy.
+func (a *Admin) DeleteJob(id string) error {
+	channel, err := a.r.hget(a.r.keys.job(id), "channel")
+	if err != nil {
+		return err
+	}
+
+	if len(channel) == 0 {
+		return ErrJobNotFound
+	}
 
-// 	if len(splitted) > 1 {
-// 		channel = splitted[1]
-// 	}
+	if err := a.r.unlistjob(id, channel); err != nil {
+		return err
+	}
 
-// 	if len(splitted) > 2 {
-// 		id = splitted[2]
-// 	}
+	if err := a.r.deletejob(id); err != nil {
+		return err
+	}
 
-// 	switch command {
-// 	case "push":
-// 		return "", a.r.pushzset(channelKey(channel), id)
-// 	case "pop":
-// 		return a.r.popfromchannel(channelKey(channel))
-// 	}
+	countjobs(metricDeleted, channel, 1)
+	return nil
+}
 
-// 	return "", nil
-// }
+// ListWatchers returns the watchers currently registered in sq_watches.
+func (a *Admin) ListWatchers() ([]WatcherInfo, error) {
+	var watchers []WatcherInfo
+	err := a.r.zscan(a.r.keys.watches(), func(member string) error {
+		name, channel, _ := strings.Cut(member, "|")
+		watchers = append(watchers, WatcherInfo{Name: name, Channel: channel})
+		return nil
+	})
+	return watchers, err
+}
+
+// PrintStore asks the running store to print its jobs to its own output.
+func (a *Admin) PrintStore() error {
+	return a.r.pushlist(a.r.keys.store(), icc(ID(), "", storePrintCommand))
+}
+
+// EmptyStore asks the running store to drop every job copy it holds.
+func (a *Admin) EmptyStore() error {
+	return a.r.pushlist(a.r.keys.store(), icc(ID(), "", storeEmptyCommand))
+}
@@ -0,0 +1,311 @@
+package smartq
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sfi2k7/blueweb"
+)
+
+//go:embed dashboard/index.html
+var dashboardhtml string
+
+type adminresponse map[string]interface{}
+
+func adminerror(c *blueweb.Context, status int, err error) {
+	c.ResponseHeader().Set("content-type", "application/json")
+	c.Status(status)
+	c.Json(adminresponse{"error": err.Error()})
+}
+
+func adminerrorstatus(err error) int {
+	if err == ErrJobNotFound {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// ServeOptions configures the admin HTTP server.
+type ServeOptions struct {
+	// Port is the port to listen on, on every interface.
+	Port int
+
+	// Token, when set, must be sent as "Authorization: Bearer <token>" with
+	// every /api request. The dashboard reads it from its ?token= parameter.
+	Token string
+
+	// Auth, when set, is called for every /api request after the token
+	// check; returning false rejects the request.
+	//
+	// Without Token and Auth the API only answers requests from loopback
+	// addresses.
+	Auth func(r *http.Request) bool
+}
+
+// authorized checks the bearer token, when set, and then the auth hook.
+func authorized(r *http.Request, token string, auth func(*http.Request) bool) bool {
+	if len(token) > 0 {
+		given := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+			return false
+		}
+	}
+
+	return auth == nil || auth(r)
+}
+
+func isloopback(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// guard rejects unauthorized requests and, for requests that change state,
+// bodies that are not json, which browsers do not send cross-site without a
+// preflight.
+func (opts ServeOptions) guard(c *blueweb.Context) bool {
+	if len(opts.Token) == 0 && opts.Auth == nil && !isloopback(c.Request) {
+		adminerror(c, http.StatusForbidden, errors.New("set a token or auth hook to serve remote clients"))
+		return false
+	}
+
+	if !authorized(c.Request, opts.Token, opts.Auth) {
+		adminerror(c, http.StatusUnauthorized, errors.New("unauthorized"))
+		return false
+	}
+
+	if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+		mt, _, err := mime.ParseMediaType(c.Header("Content-Type"))
+		if err != nil || mt != "application/json" {
+			adminerror(c, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+			return false
+		}
+	}
+
+	return true
+}
+
+// Serve is ServeWithOptions on port, answering only local clients.
+func (a *Admin) Serve(port int) error {
+	return a.ServeWithOptions(ServeOptions{Port: port})
+}
+
+// ServeWithOptions starts an HTTP server exposing the admin operations as JSON
+// under /api and the dashboard at /. It blocks until the server stops.
+func (a *Admin) ServeWithOptions(opts ServeOptions) error {
+	router := blueweb.NewRouter()
+
+	router.Get("/", func(c *blueweb.Context) {
+		c.SetHeader("content-type", "text/html; charset=utf-8")
+		c.String(dashboardhtml)
+	})
+
+	api := router.Group("/api")
+	api.Use(opts.guard)
+
+	api.Get("/channels", func(c *blueweb.Context) {
+		channels, err := a.ListChannels()
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		stats := []*ChannelStats{}
+		for _, channel := range channels {
+			s, err := a.ChannelStats(channel)
+			if err != nil {
+				adminerror(c, http.StatusInternalServerError, err)
+				return
+			}
+			stats = append(stats, s)
+		}
+
+		c.Json(adminresponse{"success": true, "channels": stats, "time": time.Now().UnixMilli()})
+	})
+
+	api.Get("/channels/:channel", func(c *blueweb.Context) {
+		stats, err := a.ChannelStats(c.Params("channel"))
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "channel": stats})
+	})
+
+	api.Get("/channels/:channel/jobs", func(c *blueweb.Context) {
+		cursor, _ := strconv.ParseUint(c.Query("cursor"), 10, 64)
+		count, err := c.QueryInt("count")
+		if err != nil || count <= 0 {
+			count = 50
+		}
+
+		page, err := a.ListJobs(c.Params("channel"), cursor, count)
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "page": page})
+	})
+
+	api.Get("/channels/:channel/working", func(c *blueweb.Context) {
+		offset, _ := c.QueryInt("offset")
+		count, err := c.QueryInt("count")
+		if err != nil || count <= 0 {
+			count = 50
+		}
+
+		page, err := a.ListWorkingSet(c.Params("channel"), offset, count)
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "page": page})
+	})
+
+	api.Post("/channels/:channel/pause", func(c *blueweb.Context) {
+		var body struct {
+			Reason   string `json:"reason"`
+			By       string `json:"by"`
+			ResumeAt int64  `json:"resume_at"`
+		}
+
+		if b, _ := c.Body(); len(b) > 0 {
+			if err := json.Unmarshal(b, &body); err != nil {
+				adminerror(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		opts := PauseOptions{Reason: body.Reason, By: body.By}
+		if body.ResumeAt > 0 {
+			opts.ResumeAt = time.Unix(body.ResumeAt, 0)
+		}
+
+		if err := a.PauseChannel(c.Params("channel"), opts); err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true})
+	})
+
+	api.Post("/channels/:channel/resume", func(c *blueweb.Context) {
+		if err := a.ResumeChannel(c.Params("channel")); err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true})
+	})
+
+	api.Post("/channels/:channel/purge", func(c *blueweb.Context) {
+		deletejobs, _ := c.QueryBool("delete_jobs")
+
+		removed, err := a.PurgeChannel(c.Params("channel"), PurgeOptions{DeleteJobs: deletejobs})
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "removed": removed})
+	})
+
+	api.Post("/channels/:channel/requeue", func(c *blueweb.Context) {
+		moved, err := a.RequeueWorkingSet(c.Params("channel"))
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "requeued": moved})
+	})
+
+	api.Delete("/channels/:channel", func(c *blueweb.Context) {
+		deletejobs, _ := c.QueryBool("delete_jobs")
+
+		removed, err := a.DeleteChannel(c.Params("channel"), PurgeOptions{DeleteJobs: deletejobs})
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "removed": removed})
+	})
+
+	api.Get("/jobs/:id", func(c *blueweb.Context) {
+		job, err := a.GetJob(c.Params("id"))
+		if err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "job": job})
+	})
+
+	api.Post("/jobs/:id/requeue", func(c *blueweb.Context) {
+		job, err := a.GetJob(c.Params("id"))
+		if err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		if err := a.RequeueJob(job.Channel, job.ID); err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true})
+	})
+
+	api.Post("/jobs/:id/move", func(c *blueweb.Context) {
+		job, err := a.GetJob(c.Params("id"))
+		if err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		if err := a.MoveJob(job.ID, job.Channel, c.Query("to")); err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true})
+	})
+
+	api.Delete("/jobs/:id", func(c *blueweb.Context) {
+		if err := a.DeleteJob(c.Params("id")); err != nil {
+			adminerror(c, adminerrorstatus(err), err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true})
+	})
+
+	api.Get("/watchers", func(c *blueweb.Context) {
+		watchers, err := a.ListWatchers()
+		if err != nil {
+			adminerror(c, http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Json(adminresponse{"success": true, "watchers": watchers})
+	})
+
+	router.Config().SetPort(opts.Port).DisableStats().StopOnInterrupt()
+
+	return router.StartServer()
+}
@@ -0,0 +1,39 @@
+package smartq
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// backoff doubles the wait after every failure, up to max, with some jitter
+// so watchers restarted together do not retry in step.
+type backoff struct {
+	min     time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newbackoff() *backoff {
+	return &backoff{
+		min: 250 * time.Millisecond,
+		max: 10 * time.Second,
+	}
+}
+
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.min
+	} else {
+		b.current = min(b.current*2, b.max)
+	}
+
+	return b.current/2 + rand.N(b.current/2+1)
+}
+
+func (b *backoff) wait() {
+	time.Sleep(b.next())
+}
+
+func (b *backoff) reset() {
+	b.current = 0
+}
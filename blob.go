@@ -0,0 +1,145 @@
+package smartq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlobStore holds job field values that are too large to keep in redis.
+// The store implements it directly; NewStoreClient reaches a store over HTTP.
+type BlobStore interface {
+	PutBlob(key string, data []byte) error
+	GetBlob(key string) ([]byte, error)
+	DeleteBlobs(prefix string) error
+}
+
+const blobBucket = "__blobs__"
+const blobprefix = "sq:ref:"
+
+var offloadmu sync.RWMutex
+var offloadstore BlobStore
+var offloadthreshold int
+
+// EnableOffload moves job field values larger than threshold bytes into bs and
+// keeps only a reference in the job hash. Pass a nil store to disable it.
+func EnableOffload(bs BlobStore, threshold int) {
+	offloadmu.Lock()
+	defer offloadmu.Unlock()
+
+	offloadstore = bs
+	offloadthreshold = threshold
+}
+
+func blobstore() (BlobStore, int) {
+	offloadmu.RLock()
+	defer offloadmu.RUnlock()
+
+	return offloadstore, offloadthreshold
+}
+
+func blobKey(jobid, field string) string {
+	return jobid + "/" + field
+}
+
+func offloadfield(jobid, field, value string) (string, error) {
+	bs, threshold := blobstore()
+	if bs == nil || len(value) <= threshold {
+		return value, nil
+	}
+
+	key := blobKey(jobid, field)
+	if err := bs.PutBlob(key, []byte(value)); err != nil {
+		return "", err
+	}
+
+	return blobprefix + key, nil
+}
+
+func loadblob(value string) (string, error) {
+	key, ok := strings.CutPrefix(value, blobprefix)
+	if !ok {
+		return value, nil
+	}
+
+	bs, _ := blobstore()
+	if bs == nil {
+		return "", errors.New("job field is offloaded but no blob store is configured")
+	}
+
+	data, err := bs.GetBlob(key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+type storeclient struct {
+	baseurl string
+	token   string
+	client  *http.Client
+}
+
+// NewStoreClient returns a BlobStore backed by the HTTP api of a store
+// started with a port, e.g. "http://localhost:9090".
+func NewStoreClient(baseurl string) BlobStore {
+	return NewStoreClientWithToken(baseurl, "")
+}
+
+// NewStoreClientWithToken is NewStoreClient for a store protected by SetAuth.
+func NewStoreClientWithToken(baseurl, token string) BlobStore {
+	return &storeclient{
+		baseurl: strings.TrimSuffix(baseurl, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (sc *storeclient) do(method, param, value string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, sc.baseurl+"/blob?"+param+"="+url.QueryEscape(value), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sc.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+sc.token)
+	}
+
+	res, err := sc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("store: %s %s", res.Status, strings.TrimSpace(string(data)))
+	}
+
+	return data, nil
+}
+
+func (sc *storeclient) PutBlob(key string, data []byte) error {
+	_, err := sc.do(http.MethodPut, "key", key, data)
+	return err
+}
+
+func (sc *storeclient) GetBlob(key string) ([]byte, error) {
+	return sc.do(http.MethodGet, "key", key, nil)
+}
+
+func (sc *storeclient) DeleteBlobs(prefix string) error {
+	_, err := sc.do(http.MethodDelete, "prefix", prefix, nil)
+	return err
+}
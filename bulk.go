@@ -0,0 +1,28 @@
+package smartq
+
+const bulkchunksize = 500
+
+type BulkJob struct {
+	ID     string
+	Fields map[string]any
+}
+
+type BulkResult struct {
+	ID  string
+	Err error
+}
+
+// InitJobs enqueues many jobs on channel using chunked pipelines. The returned
+// slice has one result per job, in the same order as jobs. Like InitJob it
+// uses the connection of SetOptions or LoadConfig; see Client.EnqueueMany.
+func InitJobs(channel string, jobs []BulkJob) ([]BulkResult, error) {
+	return initjobs(getcachedrepo(), channel, jobs)
+}
+
+func initjobs(r *repo, channel string, jobs []BulkJob) ([]BulkResult, error) {
+	if err := checkchannel(channel); err != nil {
+		return nil, err
+	}
+
+	return r.addmanytochannel(channel, jobs, bulkchunksize)
+}
@@ -0,0 +1,134 @@
+package smartq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+var errchunk = errors.New("chunk failed")
+
+// failjobhook fails every pipeline that writes the job hash key, so the
+// whole chunk holding that job fails the way it would on a dropped connection.
+type failjobhook struct {
+	key string
+}
+
+func (h failjobhook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h failjobhook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h failjobhook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	for _, cmd := range cmds {
+		if args := cmd.Args(); cmd.Name() == "hset" && len(args) > 1 && args[1] == h.key {
+			return ctx, errchunk
+		}
+	}
+	return ctx, nil
+}
+
+func (h failjobhook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+func bulkjobs(n int) []BulkJob {
+	jobs := make([]BulkJob, n)
+	for x := range jobs {
+		jobs[x] = BulkJob{ID: fmt.Sprintf("job%d", x), Fields: map[string]any{"n": x}}
+	}
+	return jobs
+}
+
+func TestAddManyToChannel(t *testing.T) {
+	tests := []struct {
+		name   string
+		jobs   int
+		failid string
+		empty  int
+		failed [2]int
+	}{
+		{"one job", 1, "", -1, [2]int{}},
+		{"below a chunk", bulkchunksize - 1, "", -1, [2]int{}},
+		{"one chunk", bulkchunksize, "", -1, [2]int{}},
+		{"one over a chunk", bulkchunksize + 1, "", -1, [2]int{}},
+		{"two chunks", 2 * bulkchunksize, "", -1, [2]int{}},
+		{"empty id", bulkchunksize + 1, "", bulkchunksize, [2]int{}},
+		{"first chunk fails", bulkchunksize + 1, "job0", -1, [2]int{0, bulkchunksize}},
+		{"middle chunk fails", 2*bulkchunksize + 1, "job600", -1, [2]int{bulkchunksize, 2 * bulkchunksize}},
+		{"last chunk fails", 2*bulkchunksize + 1, "job1000", -1, [2]int{2 * bulkchunksize, 2*bulkchunksize + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := miniredis.RunT(t)
+
+			client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+			t.Cleanup(func() { client.Close() })
+			if len(tt.failid) > 0 {
+				client.AddHook(failjobhook{key: "sq_job_{" + tt.failid + "}"})
+			}
+
+			r, err := newrepowithoptions(Options{Client: client})
+			if err != nil {
+				t.Fatalf("newrepowithoptions: %v", err)
+			}
+
+			jobs := bulkjobs(tt.jobs)
+			if tt.empty >= 0 {
+				jobs[tt.empty].ID = ""
+			}
+
+			results, err := r.addmanytochannel("orders", jobs, bulkchunksize)
+			if err != nil {
+				t.Fatalf("addmanytochannel: %v", err)
+			}
+
+			if len(results) != len(jobs) {
+				t.Fatalf("got %d results, want %d", len(results), len(jobs))
+			}
+
+			want := 0
+			for x, res := range results {
+				if res.ID != jobs[x].ID {
+					t.Fatalf("result %d: id %q, want %q", x, res.ID, jobs[x].ID)
+				}
+
+				chunkfailed := x >= tt.failed[0] && x < tt.failed[1]
+				failed := x == tt.empty || chunkfailed
+				if failed != (res.Err != nil) {
+					t.Fatalf("result %d: error %v, want error %v", x, res.Err, failed)
+				}
+
+				if chunkfailed && !errors.Is(res.Err, errchunk) {
+					t.Fatalf("result %d: got %v, want the chunk error", x, res.Err)
+				}
+
+				if !failed {
+					want++
+				}
+			}
+
+			queued, err := m.ZMembers("sq_channel_{orders}")
+			if err != nil && want > 0 {
+				t.Fatalf("channel: %v", err)
+			}
+			if len(queued) != want {
+				t.Fatalf("queued: got %d, want %d", len(queued), want)
+			}
+
+			appended, _ := strconv.Atoi(m.HGet("sq_channel_status_{orders}", "appended"))
+			if appended != want {
+				t.Fatalf("appended: got %d, want %d", appended, want)
+			}
+		})
+	}
+}
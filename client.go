@@ -0,0 +1,110 @@
+package smartq
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/codes"
+)
+
+var ErrJobNotQueued = errors.New("job is not queued or scheduled")
+
+// Client carries the producer side of smartq on its own connection. It is
+// safe for concurrent use.
+type Client struct {
+	r *repo
+}
+
+func NewClient(opts Options) (*Client, error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{r: r}, nil
+}
+
+// NewClientFromRedis is NewClient on an existing connection, which Close
+// leaves open. The connection settings of opts are ignored.
+func NewClientFromRedis(conn redis.UniversalClient, opts Options) (*Client, error) {
+	opts.Client = conn
+	return NewClient(opts)
+}
+
+func (c *Client) Close() error {
+	return c.r.Close()
+}
+
+// Enqueue is InitJobContext on the client's connection.
+func (c *Client) Enqueue(ctx context.Context, channel, id string, keyvals ...any) error {
+	return enqueuejob(ctx, c.r, channel, id, keyvals...)
+}
+
+// EnqueueMany is InitJobs on the client's connection.
+func (c *Client) EnqueueMany(channel string, jobs []BulkJob) ([]BulkResult, error) {
+	return initjobs(c.r, channel, jobs)
+}
+
+// EnqueueUnique is InitUniqueJob on the client's connection.
+func (c *Client) EnqueueUnique(channel, id string, opts UniqueOptions) (EnqueueResult, error) {
+	return enqueueunique(c.r, channel, id, opts)
+}
+
+// Schedule enqueues a job that watchers only receive once at has passed.
+func (c *Client) Schedule(ctx context.Context, channel, id string, at time.Time, keyvals ...any) error {
+	if len(id) == 0 {
+		return errors.New("id cannot be empty")
+	}
+
+	if err := checkchannel(channel); err != nil {
+		return err
+	}
+
+	if len(keyvals)%2 != 0 {
+		return errors.New("keyvals must be key/value pairs")
+	}
+
+	ctx, span := startenqueuespan(ctx, channel, id)
+	defer span.End()
+
+	keyvals = append(keyvals, tracefields(ctx)...)
+
+	err := c.r.scheduletochannel(id, channel, at, keyvals...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// Cancel deletes a job that no watcher has taken yet. It returns
+// ErrJobNotQueued when the job is being handled or already left its channel.
+func (c *Client) Cancel(id string) error {
+	obj, err := c.r.loadobjectfromhash(c.r.keys.job(id))
+	if err != nil {
+		return err
+	}
+
+	job := Job(obj)
+	if len(job) == 0 {
+		return ErrJobNotFound
+	}
+
+	cancelled, err := c.r.canceljob(id, job.Channel())
+	if err != nil {
+		return err
+	}
+
+	if !cancelled {
+		return ErrJobNotQueued
+	}
+
+	return nil
+}
+
+func (c *Client) Status(id string) (*JobInfo, error) {
+	return c.r.jobinfo(id)
+}
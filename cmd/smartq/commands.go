@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sfi2k7/smartq"
+)
+
+func enqueue(a *smartq.Admin, args []string) error {
+	fs := flag.NewFlagSet("enqueue", flag.ExitOnError)
+	id := fs.String("id", "", "job id, generated when empty")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("enqueue: channel is required")
+	}
+
+	if len(*id) == 0 {
+		*id = smartq.ID()
+	}
+
+	var keyvals []any
+	for _, field := range fs.Args()[1:] {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok {
+			return fmt.Errorf("enqueue: field %q is not key=value", field)
+		}
+		keyvals = append(keyvals, k, v)
+	}
+
+	c, err := smartq.NewClient(options)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if err := c.Enqueue(context.Background(), fs.Arg(0), *id, keyvals...); err != nil {
+		return err
+	}
+
+	fmt.Println(*id)
+	return nil
+}
+
+func job(a *smartq.Admin, args []string) error {
+	if len(args) < 1 {
+		return errors.New("job: id is required")
+	}
+
+	info, err := a.GetJob(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("id:     ", info.ID)
+	fmt.Println("channel:", info.Channel)
+	fmt.Println("state:  ", info.State)
+	fmt.Println("created:", info.Created.Format(time.RFC3339))
+
+	var keys []string
+	for k := range info.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\nFIELD\tVALUE")
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", k, info.Fields.String(k))
+	}
+	return w.Flush()
+}
+
+func channels(a *smartq.Admin, args []string) error {
+	names, err := a.ListChannels()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CHANNEL\tSTATE\tDEPTH\tINFLIGHT\tPROCESSED\tFAILED\tOLDEST\tP50\tP99\t")
+	for _, name := range names {
+		s, err := a.ChannelStats(name)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\t\n",
+			s.Channel, state(s), s.Depth, s.InFlight, s.Processed, s.Failed,
+			s.OldestAge.Round(time.Second), s.LatencyP50, s.LatencyP99)
+	}
+	return w.Flush()
+}
+
+func state(s *smartq.ChannelStats) string {
+	switch {
+	case s.Paused:
+		return "paused"
+	case s.Draining:
+		return "draining"
+	}
+	return "running"
+}
+
+func pause(a *smartq.Admin, args []string) error {
+	fs := flag.NewFlagSet("pause", flag.ExitOnError)
+	reason := fs.String("reason", "", "why the channel is paused")
+	by := fs.String("by", os.Getenv("USER"), "who paused the channel")
+	resumein := fs.Duration("for", 0, "resume automatically after this long")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("pause: channel is required")
+	}
+
+	opts := smartq.PauseOptions{Reason: *reason, By: *by}
+	if *resumein > 0 {
+		opts.ResumeAt = time.Now().Add(*resumein)
+	}
+
+	return a.PauseChannel(fs.Arg(0), opts)
+}
+
+func resume(a *smartq.Admin, args []string) error {
+	if len(args) < 1 {
+		return errors.New("resume: channel is required")
+	}
+
+	return a.ResumeChannel(args[0])
+}
+
+func purge(a *smartq.Admin, args []string) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	deletejobs := fs.Bool("delete-jobs", false, "also delete the job data and store copies")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		return errors.New("purge: channel is required")
+	}
+
+	removed, err := a.PurgeChannel(fs.Arg(0), smartq.PurgeOptions{DeleteJobs: *deletejobs})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("removed", removed, "jobs")
+	return nil
+}
+
+func migrate(a *smartq.Admin, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	storefile := fs.String("store", "", "also move the buckets of this stopped store file into the namespace")
+	fs.Parse(args)
+
+	renamed, err := a.MigrateLegacyKeys(context.Background())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("renamed", renamed, "keys")
+
+	if len(*storefile) == 0 {
+		return nil
+	}
+
+	moved, err := smartq.MigrateStoreBuckets(*storefile, a.Namespace())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("moved", moved, "store entries")
+	return nil
+}
+
+func requeue(a *smartq.Admin, args []string) error {
+	if len(args) < 1 {
+		return errors.New("requeue: channel is required")
+	}
+
+	moved, err := a.RequeueWorkingSet(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("requeued", moved, "jobs")
+	return nil
+}
+
+// tail polls channel stats every second and prints the counters that changed.
+// It is a stats tail, not an event stream: changes within one second are
+// summed and individual jobs are not shown.
+func tail(a *smartq.Admin, args []string) error {
+	ex := make(chan os.Signal, 1)
+	signal.Notify(ex, os.Interrupt)
+	defer signal.Stop(ex)
+
+	previous := map[string]*smartq.ChannelStats{}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		names := args
+		if len(names) == 0 {
+			var err error
+			if names, err = a.ListChannels(); err != nil {
+				return err
+			}
+		}
+
+		for _, name := range names {
+			s, err := a.ChannelStats(name)
+			if err != nil {
+				return err
+			}
+
+			if prev, ok := previous[name]; ok {
+				if events := diff(prev, s); len(events) > 0 {
+					fmt.Printf("%s %s %s\n", time.Now().Format("15:04:05"), name, strings.Join(events, " "))
+				}
+			}
+			previous[name] = s
+		}
+
+		select {
+		case <-ex:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func diff(prev, cur *smartq.ChannelStats) []string {
+	var events []string
+	counter := func(name string, before, after int64) {
+		if after != before {
+			events = append(events, fmt.Sprintf("%s%+d", name, after-before))
+		}
+	}
+
+	counter("appended", prev.Appended, cur.Appended)
+	counter("routed", prev.Routed, cur.Routed)
+	counter("processed", prev.Processed, cur.Processed)
+	counter("failed", prev.Failed, cur.Failed)
+
+	if prev.Paused != cur.Paused || prev.Draining != cur.Draining {
+		events = append(events, "state="+state(cur))
+	}
+
+	if len(events) > 0 {
+		events = append(events, fmt.Sprintf("depth=%d inflight=%d", cur.Depth, cur.InFlight))
+	}
+
+	return events
+}
+
+func store(a *smartq.Admin, args []string) error {
+	if len(args) < 1 {
+		return errors.New("store: print or empty is required")
+	}
+
+	switch args[0] {
+	case "print":
+		return a.PrintStore()
+	case "empty":
+		return a.EmptyStore()
+	}
+
+	return fmt.Errorf("store: unknown command %q", args[0])
+}
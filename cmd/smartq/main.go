@@ -0,0 +1,96 @@
+// Command smartq inspects and manages smartq channels, jobs and the store.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/sfi2k7/smartq"
+)
+
+const usage = `usage: smartq [-config file] <command> [arguments]
+
+commands:
+  enqueue [-id id] <channel> [key=value ...]   enqueue a job
+  job <id>                                     inspect a job
+  channels                                     list channels with stats
+  pause [-reason r] [-by who] [-for d] <channel>
+  resume <channel>
+  purge [-delete-jobs] <channel>
+  requeue <channel>                            requeue the working set
+  tail [channel ...]                           poll channel stats every second and
+                                               print the counters that changed
+  top                                          live view of channels and watchers
+  store print|empty                            send a command to the running store
+  migrate [-store file]                        move keys written by older versions
+                                               or without a namespace
+
+Without -config the SMARTQ_REDIS_* environment variables are used.
+`
+
+type command func(a *smartq.Admin, args []string) error
+
+// options is the configuration the admin was built from, for commands that
+// need their own client.
+var options smartq.Options
+
+var commands = map[string]command{
+	"enqueue":  enqueue,
+	"job":      job,
+	"channels": channels,
+	"pause":    pause,
+	"resume":   resume,
+	"purge":    purge,
+	"requeue":  requeue,
+	"tail":     tail,
+	"top":      top,
+	"store":    store,
+	"migrate":  migrate,
+}
+
+func main() {
+	configfile := flag.String("config", "", "json or yaml config file, as read by smartq.LoadConfig")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintln(os.Stderr, "unknown command:", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var opts smartq.Options
+	var err error
+	if len(*configfile) > 0 {
+		opts, err = smartq.OptionsFromFile(*configfile)
+	} else {
+		opts, err = smartq.OptionsFromEnv()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
+	}
+
+	options = opts
+
+	admin, err := smartq.NewAdminWithOptions(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+
+	if err := cmd(admin, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sfi2k7/smartq"
+	"golang.org/x/term"
+)
+
+// topsnapshot is what one refresh of the top screen shows.
+type topsnapshot struct {
+	at       time.Time
+	stats    []*smartq.ChannelStats
+	watchers []smartq.WatcherInfo
+	inrate   map[string]string
+	outrate  map[string]string
+	err      error
+}
+
+type topscreen struct {
+	a        *smartq.Admin
+	selected int
+	previous map[string]*smartq.ChannelStats
+	prevat   time.Time
+	message  string
+}
+
+// top shows a live view of every channel and watcher, refreshed every second.
+// p pauses and r resumes the selected channel, q quits.
+func top(a *smartq.Admin, args []string) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return fmt.Errorf("top: stdin is not a terminal")
+	}
+
+	oldstate, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, oldstate)
+
+	//alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan string)
+	go readkeys(keys)
+
+	t := &topscreen{a: a, previous: map[string]*smartq.ChannelStats{}}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	snap := t.load()
+	t.render(snap)
+
+	for {
+		select {
+		case key, ok := <-keys:
+			//stdin closed
+			if !ok {
+				return nil
+			}
+
+			switch key {
+			case "q", "\x03":
+				return nil
+			case "up", "k":
+				if t.selected > 0 {
+					t.selected--
+				}
+			case "down", "j":
+				if t.selected < len(snap.stats)-1 {
+					t.selected++
+				}
+			case "p", "r":
+				t.toggle(snap, key == "p")
+				snap = t.load()
+			}
+			t.render(snap)
+		case <-ticker.C:
+			snap = t.load()
+			t.render(snap)
+		}
+	}
+}
+
+func readkeys(keys chan<- string) {
+	buf := make([]byte, 8)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+
+		switch s := string(buf[:n]); s {
+		case "\x1b[A":
+			keys <- "up"
+		case "\x1b[B":
+			keys <- "down"
+		default:
+			keys <- s
+		}
+	}
+}
+
+func (t *topscreen) toggle(snap topsnapshot, pause bool) {
+	if t.selected >= len(snap.stats) {
+		return
+	}
+
+	channel := snap.stats[t.selected].Channel
+
+	var err error
+	if pause {
+		err = t.a.PauseChannel(channel, smartq.PauseOptions{Reason: "paused from smartq top", By: os.Getenv("USER")})
+		t.message = "paused " + channel
+	} else {
+		err = t.a.ResumeChannel(channel)
+		t.message = "resumed " + channel
+	}
+
+	if err != nil {
+		t.message = err.Error()
+	}
+}
+
+func (t *topscreen) load() topsnapshot {
+	snap := topsnapshot{at: time.Now()}
+
+	names, err := t.a.ListChannels()
+	if err != nil {
+		snap.err = err
+		return snap
+	}
+
+	for _, name := range names {
+		s, err := t.a.ChannelStats(name)
+		if err != nil {
+			snap.err = err
+			return snap
+		}
+		snap.stats = append(snap.stats, s)
+	}
+
+	snap.watchers, snap.err = t.a.ListWatchers()
+	if snap.err != nil {
+		return snap
+	}
+
+	snap.inrate = map[string]string{}
+	snap.outrate = map[string]string{}
+	for _, s := range snap.stats {
+		snap.inrate[s.Channel] = t.rate(s, snap.at, func(c *smartq.ChannelStats) int64 { return c.Appended + c.Routed })
+		snap.outrate[s.Channel] = t.rate(s, snap.at, func(c *smartq.ChannelStats) int64 { return c.Processed })
+		t.previous[s.Channel] = s
+	}
+	t.prevat = snap.at
+
+	return snap
+}
+
+func (t *topscreen) rate(s *smartq.ChannelStats, at time.Time, counter func(*smartq.ChannelStats) int64) string {
+	prev, ok := t.previous[s.Channel]
+	if !ok || !at.After(t.prevat) {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.1f", float64(counter(s)-counter(prev))/at.Sub(t.prevat).Seconds())
+}
+
+func (t *topscreen) render(snap topsnapshot) {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\x1b[K\r\n")
+	}
+
+	b.WriteString("\x1b[H")
+	line("smartq top - %s - %d channels, %d watchers", snap.at.Format("15:04:05"), len(snap.stats), len(snap.watchers))
+	line("")
+
+	watching := map[string]int{}
+	for _, w := range snap.watchers {
+		watching[w.Channel]++
+	}
+
+	line("\x1b[7m  %-24s %-9s %9s %9s %8s %8s %10s %8s %8s %9s %8s \x1b[0m",
+		"CHANNEL", "STATE", "DEPTH", "INFLIGHT", "IN/S", "OUT/S", "PROCESSED", "FAILED", "WATCHERS", "OLDEST", "P99")
+
+	if t.selected >= len(snap.stats) {
+		t.selected = max(len(snap.stats)-1, 0)
+	}
+
+	for x, s := range snap.stats {
+		marker, style := "  ", ""
+		if x == t.selected {
+			marker, style = "> ", "\x1b[1m"
+		}
+
+		st := state(s)
+		if st != "running" {
+			st = "\x1b[33m" + fmt.Sprintf("%-9s", st) + "\x1b[0m" + style
+		} else {
+			st = fmt.Sprintf("%-9s", st)
+		}
+
+		line("%s%s%-24s %s %9d %9d %8s %8s %10d %8d %8d %9s %8s\x1b[0m",
+			style, marker, s.Channel, st, s.Depth, s.InFlight,
+			snap.inrate[s.Channel], snap.outrate[s.Channel],
+			s.Processed, s.Failed, watching[s.Channel], s.OldestAge.Round(time.Second), s.LatencyP99)
+	}
+
+	line("")
+	line("\x1b[7m  %-40s %-24s \x1b[0m", "WATCHER", "CHANNEL")
+	for _, w := range snap.watchers {
+		line("  %-40s %-24s", w.Name, w.Channel)
+	}
+
+	line("")
+	if snap.err != nil {
+		line("\x1b[31merror: %s\x1b[0m", snap.err)
+	} else {
+		line("%s", t.message)
+	}
+	line("up/down select  p pause  r resume  q quit")
+	b.WriteString("\x1b[J")
+
+	fmt.Print(b.String())
+}
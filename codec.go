@@ -0,0 +1,145 @@
+package smartq
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes objects stored with SetObj or as nested job fields.
+// Stored values are prefixed with "sq:<name>:" so readers pick the right codec;
+// values without a marker are treated as JSON.
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+const codecprefix = "sq:"
+
+type jsoncodec struct{}
+
+func (jsoncodec) Name() string                       { return "json" }
+func (jsoncodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsoncodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type msgpackcodec struct{}
+
+func (msgpackcodec) Name() string                       { return "msgpack" }
+func (msgpackcodec) Marshal(v any) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackcodec) Unmarshal(data []byte, v any) error { return msgpack.Unmarshal(data, v) }
+
+type gobcodec struct{}
+
+func (gobcodec) Name() string { return "gob" }
+
+func (gobcodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobcodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+var JSONCodec Codec = jsoncodec{}
+var MsgpackCodec Codec = msgpackcodec{}
+var GobCodec Codec = gobcodec{}
+
+var codecsmu sync.RWMutex
+var codecs = map[string]Codec{
+	JSONCodec.Name():    JSONCodec,
+	MsgpackCodec.Name(): MsgpackCodec,
+	GobCodec.Name():     GobCodec,
+}
+var defaultcodec = JSONCodec
+var channelcodecs = map[string]Codec{}
+
+// RegisterCodec makes a custom codec available for decoding.
+func RegisterCodec(c Codec) {
+	codecsmu.Lock()
+	defer codecsmu.Unlock()
+
+	codecs[c.Name()] = c
+}
+
+// SetCodec sets the codec used for channels without their own codec.
+func SetCodec(c Codec) {
+	codecsmu.Lock()
+	defer codecsmu.Unlock()
+
+	codecs[c.Name()] = c
+	defaultcodec = c
+}
+
+func SetChannelCodec(channel string, c Codec) {
+	codecsmu.Lock()
+	defer codecsmu.Unlock()
+
+	codecs[c.Name()] = c
+	channelcodecs[channel] = c
+}
+
+func codecfor(channel string) Codec {
+	codecsmu.RLock()
+	defer codecsmu.RUnlock()
+
+	if c, ok := channelcodecs[channel]; ok {
+		return c
+	}
+
+	return defaultcodec
+}
+
+func encodeobj(c Codec, v any) (string, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	//json is kept readable, binary codecs are base64 encoded
+	if c.Name() == JSONCodec.Name() {
+		return codecprefix + c.Name() + ":" + string(data), nil
+	}
+
+	return codecprefix + c.Name() + ":" + base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeobj(s string, v any) error {
+	if !strings.HasPrefix(s, codecprefix) {
+		return json.Unmarshal([]byte(s), v)
+	}
+
+	name, payload, ok := strings.Cut(strings.TrimPrefix(s, codecprefix), ":")
+	if !ok {
+		return json.Unmarshal([]byte(s), v)
+	}
+
+	codecsmu.RLock()
+	c, ok := codecs[name]
+	codecsmu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("codec %s is not registered", name)
+	}
+
+	if name == JSONCodec.Name() {
+		return c.Unmarshal([]byte(payload), v)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return err
+	}
+
+	return c.Unmarshal(data, v)
+}
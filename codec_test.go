@@ -0,0 +1,120 @@
+package smartq
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testpayload struct {
+	Name  string
+	Count int
+	Tags  []string
+}
+
+// uppercodec is a custom codec, found by decodeobj through RegisterCodec.
+type uppercodec struct{}
+
+func (uppercodec) Name() string                       { return "upper" }
+func (uppercodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (uppercodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+func TestCodecRoundTrip(t *testing.T) {
+	RegisterCodec(uppercodec{})
+
+	in := testpayload{Name: "order", Count: 3, Tags: []string{"a", "b"}}
+
+	tests := []struct {
+		codec  Codec
+		marker string
+	}{
+		{JSONCodec, "sq:json:"},
+		{MsgpackCodec, "sq:msgpack:"},
+		{GobCodec, "sq:gob:"},
+		{uppercodec{}, "sq:upper:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.codec.Name(), func(t *testing.T) {
+			s, err := encodeobj(tt.codec, in)
+			if err != nil {
+				t.Fatalf("encodeobj: %v", err)
+			}
+
+			if !strings.HasPrefix(s, tt.marker) {
+				t.Fatalf("value %q has no %s marker", s, tt.marker)
+			}
+
+			var out testpayload
+			if err := decodeobj(s, &out); err != nil {
+				t.Fatalf("decodeobj: %v", err)
+			}
+
+			if !reflect.DeepEqual(out, in) {
+				t.Fatalf("got %+v, want %+v", out, in)
+			}
+		})
+	}
+}
+
+func TestDecodeMixedValues(t *testing.T) {
+	want := testpayload{Name: "order", Count: 3}
+
+	marked, err := encodeobj(MsgpackCodec, want)
+	if err != nil {
+		t.Fatalf("encodeobj: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		err   bool
+	}{
+		{"marked", marked, false},
+		{"unmarked json", `{"Name":"order","Count":3}`, false},
+		{"marked json", `sq:json:{"Name":"order","Count":3}`, false},
+		{"unregistered codec", "sq:nope:e30=", true},
+		{"bad base64", "sq:msgpack:!!", true},
+		{"marker without payload", "sq:json", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out testpayload
+			err := decodeobj(tt.value, &out)
+			if (err != nil) != tt.err {
+				t.Fatalf("error: got %v, want error %v", err, tt.err)
+			}
+
+			if !tt.err && !reflect.DeepEqual(out, want) {
+				t.Fatalf("got %+v, want %+v", out, want)
+			}
+		})
+	}
+}
+
+func TestChannelCodec(t *testing.T) {
+	SetChannelCodec("test_msgpack_channel", MsgpackCodec)
+	t.Cleanup(func() {
+		codecsmu.Lock()
+		delete(channelcodecs, "test_msgpack_channel")
+		codecsmu.Unlock()
+	})
+
+	tests := []struct {
+		channel string
+		want    Codec
+	}{
+		{"test_msgpack_channel", MsgpackCodec},
+		{"other", JSONCodec},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if got := codecfor(tt.channel); got.Name() != tt.want.Name() {
+				t.Fatalf("got %s, want %s", got.Name(), tt.want.Name())
+			}
+		})
+	}
+}
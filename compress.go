@@ -0,0 +1,79 @@
+package smartq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"strings"
+	"sync"
+)
+
+const gzipprefix = "sq:gz:"
+
+var compressmu sync.RWMutex
+var compressthreshold int
+
+// EnableCompression gzips job field values and store snapshots larger than
+// threshold bytes. Compressed values are tagged and decompressed on read,
+// so uncompressed data stays readable. A threshold of 0 disables it.
+func EnableCompression(threshold int) {
+	compressmu.Lock()
+	defer compressmu.Unlock()
+
+	compressthreshold = threshold
+}
+
+func compressfield(v string) (string, error) {
+	compressmu.RLock()
+	threshold := compressthreshold
+	compressmu.RUnlock()
+
+	if threshold <= 0 || len(v) <= threshold {
+		return v, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(v)); err != nil {
+		return "", err
+	}
+
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	compressed := gzipprefix + base64.StdEncoding.EncodeToString(buf.Bytes())
+
+	//not worth it for data that does not compress
+	if len(compressed) >= len(v) {
+		return v, nil
+	}
+
+	return compressed, nil
+}
+
+func decompressfield(v string) (string, error) {
+	payload, ok := strings.CutPrefix(v, gzipprefix)
+	if !ok {
+		return v, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	plain, err := io.ReadAll(zr)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
@@ -0,0 +1,98 @@
+package smartq
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompressRoundTrip(t *testing.T) {
+	EnableCompression(64)
+	t.Cleanup(func() { EnableCompression(0) })
+
+	tests := []struct {
+		name       string
+		value      string
+		compressed bool
+	}{
+		{"empty", "", false},
+		{"below threshold", "short value", false},
+		{"at threshold", strings.Repeat("a", 64), false},
+		{"compressible", strings.Repeat("smartq ", 100), true},
+		{"incompressible", "0c3f8a1e9b7d2654f0e1a9c8b7d6e5f4a3b2c1d0e9f8a7b6c5d4e3f2a1b0c9d8e7f6", false},
+		{"json", `{"items":[` + strings.Repeat(`{"sku":"a","qty":1},`, 20) + `{}]}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := compressfield(tt.value)
+			if err != nil {
+				t.Fatalf("compressfield: %v", err)
+			}
+
+			if got := strings.HasPrefix(v, gzipprefix); got != tt.compressed {
+				t.Fatalf("compressed: got %v, want %v", got, tt.compressed)
+			}
+
+			if tt.compressed && len(v) >= len(tt.value) {
+				t.Fatalf("compressed value is not smaller: %d >= %d", len(v), len(tt.value))
+			}
+
+			plain, err := decompressfield(v)
+			if err != nil {
+				t.Fatalf("decompressfield: %v", err)
+			}
+
+			if plain != tt.value {
+				t.Fatalf("got %q, want %q", plain, tt.value)
+			}
+		})
+	}
+}
+
+func TestCompressDisabled(t *testing.T) {
+	value := strings.Repeat("smartq ", 100)
+
+	v, err := compressfield(value)
+	if err != nil {
+		t.Fatalf("compressfield: %v", err)
+	}
+
+	if v != value {
+		t.Fatal("value was compressed with compression disabled")
+	}
+}
+
+func TestDecompressMixedValues(t *testing.T) {
+	EnableCompression(16)
+	compressed, err := compressfield(strings.Repeat("x", 200))
+	EnableCompression(0)
+	if err != nil {
+		t.Fatalf("compressfield: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		want  string
+		err   bool
+	}{
+		{"compressed", compressed, strings.Repeat("x", 200), false},
+		{"unmarked", "written before compression", "written before compression", false},
+		{"other marker", "sq:json:{}", "sq:json:{}", false},
+		{"bad base64", gzipprefix + "!!", "", true},
+		{"not gzip", gzipprefix + "aGVsbG8=", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decompressfield(tt.value)
+			if (err != nil) != tt.err {
+				t.Fatalf("error: got %v, want error %v", err, tt.err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,8 @@
 package smartq
 
 import (
-	"encoding/json"
-	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 )
 
 type Config struct {
@@ -17,78 +14,78 @@ type Config struct {
 var defaulturl = "localhost:6379"
 var defaultpassword = "passme"
 
-var redisurl string
-var redispassword string
-
-func LoadConfig(up ...string) error {
+// loadedoptions are the settings read by LoadConfig or given to SetOptions,
+// used by the constructors that do not take Options.
+var loadedoptions Options
+
+// optionsset is true once SetOptions was called; LoadConfig is then skipped
+// by the store.
+var optionsset bool
+
+// SetOptions makes the package-level helpers and constructors without
+// Options (InitJob, InitJobs, InitUniqueJob, NewWatch, NewProducer, NewAdmin,
+// NewStore and MetricsHandler) use opts instead of LoadConfig. With
+// opts.Client set they share that connection and never dial their own.
+// Call it before using any of them.
+func SetOptions(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
 
-	fmt.Println("loading configuration...")
-	defer func() {
-		fmt.Println("done loading configuration")
-	}()
+	cachedrepolock.Lock()
+	defer cachedrepolock.Unlock()
 
-	if len(up) > 0 {
-		if len(up) > 0 {
-			redispassword = up[0]
-		}
-
-		if len(up) > 1 {
-			redisurl = up[1]
-		}
+	if cachedrepo != nil {
+		cachedrepo.Close()
+		cachedrepo = nil
 	}
 
+	loadedoptions = opts
+	optionsset = true
+	return nil
+}
+
+// LoadConfig reads the config file named by the first command line argument,
+// when it is a json or yaml file. The optional password and address
+// arguments override the file.
+func LoadConfig(up ...string) error {
 	var configfilename string
 	if len(os.Args) > 1 {
-		configfilename = os.Args[1]
-		if strings.HasPrefix(configfilename, "--") {
-			configfilename = ""
+		switch filepath.Ext(os.Args[1]) {
+		case ".json", ".yaml", ".yml":
+			configfilename = os.Args[1]
 		}
 	}
 
-	if len(configfilename) == 0 {
-		fmt.Println("config filename", configfilename)
-		redisurl = defaulturl
-		redispassword = defaultpassword
-		fmt.Println("using default user/password")
-		return nil
+	if err := LoadConfigFile(configfilename); err != nil {
+		return err
 	}
 
-	ext := filepath.Ext(configfilename)
-	if ext == ".json" {
-
-		var config = make(map[string]string)
-		bytes, err := os.ReadFile(configfilename)
-		if err != nil {
-			panic(err)
-		}
-
-		err = json.Unmarshal(bytes, &config)
-		if err != nil {
-			panic(err)
-		}
+	if len(up) > 0 {
+		loadedoptions.Password = up[0]
+	}
 
-		url, ok := config["url"]
-		if !ok {
-			url = defaulturl
-		}
+	if len(up) > 1 {
+		loadedoptions.Addr = up[1]
+	}
 
-		password, ok := config["password"]
-		if !ok {
-			password = defaultpassword
-		}
+	return nil
+}
 
-		redisurl = url
-		redispassword = password
+// LoadConfigFile reads the redis settings from a json or yaml config file,
+// or uses the defaults when filename is empty.
+func LoadConfigFile(configfilename string) error {
+	if len(configfilename) == 0 {
+		loadedoptions = DefaultOptions()
+		logger().Info("no config file, using default redis settings", "addr", loadedoptions.Addr)
 		return nil
 	}
 
-	// if ext == ".yaml" {
-	// 	loadYamlConfig(filename)
-	// } else if ext == ".json" {
-	// 	loadJsonConfig(filename)
-	// } else {
-	// 	loadDefaultConfig()
-	// }
+	opts, err := OptionsFromFile(configfilename)
+	if err != nil {
+		return err
+	}
 
+	loadedoptions = opts
 	return nil
 }
@@ -0,0 +1,177 @@
+package smartq
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Keyring holds the AES keys used for field encryption. Values are always
+// encrypted with the primary key and carry its id, so older keys can stay in
+// the ring for reading while a new primary is rolled out.
+type Keyring struct {
+	mu      sync.RWMutex
+	primary string
+	keys    map[string]cipher.AEAD
+}
+
+const encprefix = "sq:enc:"
+
+var ErrNoKey = errors.New("no key to decrypt job field")
+
+// fields that smartq itself reads are never encrypted
+var reservedfields = []string{"id", "channel", "created", traceparentfield, tracestatefield}
+
+func NewKeyring() *Keyring {
+	return &Keyring{
+		keys: map[string]cipher.AEAD{},
+	}
+}
+
+// Add registers a 16, 24 or 32 byte AES key under id. The first key added
+// becomes the primary key.
+func (k *Keyring) Add(id string, key []byte) error {
+	if len(id) == 0 || strings.Contains(id, ":") {
+		return errors.New("key id cannot be empty or contain ':'")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.keys[id] = aead
+	if len(k.primary) == 0 {
+		k.primary = id
+	}
+
+	return nil
+}
+
+func (k *Keyring) SetPrimary(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("key %s is not in the keyring", id)
+	}
+
+	k.primary = id
+	return nil
+}
+
+func (k *Keyring) encrypt(plain string) (string, error) {
+	k.mu.RLock()
+	id := k.primary
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+
+	if !ok {
+		return "", errors.New("keyring has no primary key")
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plain), nil)
+
+	return encprefix + id + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *Keyring) decrypt(value string) (string, error) {
+	id, payload, ok := strings.Cut(strings.TrimPrefix(value, encprefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: key %s", ErrNoKey, id)
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plain), nil
+}
+
+var cryptmu sync.RWMutex
+var cryptkeys *Keyring
+var cryptfields []string
+
+// EnableEncryption encrypts the given job fields with AES-GCM before they are
+// written. Without fields every field except id, channel and created is
+// encrypted. Processes that read encrypted jobs need the same keyring.
+func EnableEncryption(kr *Keyring, fields ...string) {
+	cryptmu.Lock()
+	defer cryptmu.Unlock()
+
+	cryptkeys = kr
+	cryptfields = fields
+}
+
+func keyring() *Keyring {
+	cryptmu.RLock()
+	defer cryptmu.RUnlock()
+
+	return cryptkeys
+}
+
+func encryptfield(field, value string) (string, error) {
+	cryptmu.RLock()
+	kr, fields := cryptkeys, cryptfields
+	cryptmu.RUnlock()
+
+	if kr == nil || slices.Contains(reservedfields, field) {
+		return value, nil
+	}
+
+	if len(fields) > 0 && !slices.Contains(fields, field) {
+		return value, nil
+	}
+
+	return kr.encrypt(value)
+}
+
+func decryptfield(value string) (string, error) {
+	if !strings.HasPrefix(value, encprefix) {
+		return value, nil
+	}
+
+	kr := keyring()
+	if kr == nil {
+		return "", ErrNoKey
+	}
+
+	return kr.decrypt(value)
+}
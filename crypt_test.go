@@ -0,0 +1,187 @@
+package smartq
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func testkey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func testkeyring(t *testing.T, ids ...string) *Keyring {
+	t.Helper()
+
+	kr := NewKeyring()
+	for x, id := range ids {
+		if err := kr.Add(id, testkey(byte(x+1))); err != nil {
+			t.Fatalf("Add %s: %v", id, err)
+		}
+	}
+	return kr
+}
+
+func TestKeyringRotation(t *testing.T) {
+	kr := testkeyring(t, "k1")
+
+	old, err := kr.encrypt("secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	if err := kr.Add("k2", testkey(2)); err != nil {
+		t.Fatalf("Add k2: %v", err)
+	}
+
+	if err := kr.SetPrimary("k2"); err != nil {
+		t.Fatalf("SetPrimary: %v", err)
+	}
+
+	current, err := kr.encrypt("secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		keyid string
+	}{
+		{"old key", old, "k1"},
+		{"new primary", current, "k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.HasPrefix(tt.value, encprefix+tt.keyid+":") {
+				t.Fatalf("value %q is not tagged with key %s", tt.value, tt.keyid)
+			}
+
+			plain, err := kr.decrypt(tt.value)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+
+			if plain != "secret" {
+				t.Fatalf("got %q", plain)
+			}
+		})
+	}
+}
+
+func TestKeyringErrors(t *testing.T) {
+	kr := testkeyring(t, "k1")
+	sealed, err := kr.encrypt("secret")
+	if err != nil {
+		t.Fatalf("encrypt: %v", err)
+	}
+
+	tampered := sealed[:len(sealed)-4] + "AAA="
+
+	tests := []struct {
+		name  string
+		kr    *Keyring
+		value string
+		want  error
+	}{
+		{"missing key", testkeyring(t, "k2"), sealed, ErrNoKey},
+		{"empty keyring", NewKeyring(), sealed, ErrNoKey},
+		{"tampered", kr, tampered, nil},
+		{"malformed", kr, encprefix + "k1", nil},
+		{"bad base64", kr, encprefix + "k1:!!", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.kr.decrypt(tt.value)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyringAdd(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		key  []byte
+	}{
+		{"empty id", "", testkey(1)},
+		{"id with colon", "a:b", testkey(1)},
+		{"short key", "k1", []byte("short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewKeyring().Add(tt.id, tt.key); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+
+	if err := NewKeyring().SetPrimary("k1"); err == nil {
+		t.Fatal("SetPrimary accepted an unknown key")
+	}
+}
+
+func TestEncryptFields(t *testing.T) {
+	EnableEncryption(testkeyring(t, "k1"), "card")
+	t.Cleanup(func() { EnableEncryption(nil) })
+
+	tests := []struct {
+		field     string
+		encrypted bool
+	}{
+		{"card", true},
+		{"note", false},
+		{"id", false},
+		{"channel", false},
+		{traceparentfield, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			v, err := encryptfield(tt.field, "value")
+			if err != nil {
+				t.Fatalf("encryptfield: %v", err)
+			}
+
+			if got := strings.HasPrefix(v, encprefix); got != tt.encrypted {
+				t.Fatalf("encrypted: got %v, want %v", got, tt.encrypted)
+			}
+
+			plain, err := unpackfield(v)
+			if err != nil {
+				t.Fatalf("unpackfield: %v", err)
+			}
+
+			if plain != "value" {
+				t.Fatalf("got %q", plain)
+			}
+		})
+	}
+}
+
+func TestDecryptWithoutKeyring(t *testing.T) {
+	EnableEncryption(testkeyring(t, "k1"))
+	v, err := encryptfield("card", "value")
+	EnableEncryption(nil)
+	if err != nil {
+		t.Fatalf("encryptfield: %v", err)
+	}
+
+	if _, err := decryptfield(v); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("got %v, want ErrNoKey", err)
+	}
+
+	if plain, err := decryptfield("plain"); err != nil || plain != "plain" {
+		t.Fatalf("unencrypted value: got %q, %v", plain, err)
+	}
+}
@@ -0,0 +1,294 @@
+package smartq
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct fields are mapped to job fields with the `smartq` tag:
+//
+//	type Order struct {
+//		ID      string        `smartq:"id"`
+//		Amount  int64         `smartq:"amount"`
+//		Items   []Item        `smartq:"items"`
+//		Timeout time.Duration `smartq:"timeout"`
+//		Due     time.Time     `smartq:"due,ms"`
+//		Note    string        `smartq:"note,omitempty"`
+//	}
+//
+// Untagged fields use their lowercased name and "-" skips a field. Times and
+// durations are written in seconds, or milliseconds with the "ms" option; a
+// duration with a finer remainder is written as a Go duration string. Slices,
+// maps and structs are stored with the default codec, the same way SetObj writes them.
+
+var timeType = reflect.TypeOf(time.Time{})
+var durationType = reflect.TypeOf(time.Duration(0))
+
+type fieldtag struct {
+	name      string
+	ms        bool
+	omitempty bool
+}
+
+func parsefieldtag(f reflect.StructField) (fieldtag, bool) {
+	tag, ok := f.Tag.Lookup("smartq")
+	if tag == "-" {
+		return fieldtag{}, false
+	}
+
+	var ft fieldtag
+	if ok {
+		parts := strings.Split(tag, ",")
+		ft.name = parts[0]
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "ms":
+				ft.ms = true
+			case "omitempty":
+				ft.omitempty = true
+			}
+		}
+	}
+
+	if len(ft.name) == 0 {
+		ft.name = strings.ToLower(f.Name)
+	}
+
+	return ft, true
+}
+
+func structvalue(v any) (reflect.Value, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return reflect.Value{}, errors.New("smartq: nil pointer")
+		}
+		rv = rv.Elem()
+	}
+
+	if rv.Kind() != reflect.Struct {
+		return reflect.Value{}, fmt.Errorf("smartq: expected struct, got %s", rv.Kind())
+	}
+
+	return rv, nil
+}
+
+// Decode copies the job fields into the struct pointed to by v.
+// Missing or empty fields leave the struct field untouched.
+func (j Job) Decode(v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("smartq: Decode requires a non-nil pointer")
+	}
+
+	rv, err := structvalue(v)
+	if err != nil {
+		return err
+	}
+
+	rt := rv.Type()
+	for x := 0; x < rt.NumField(); x++ {
+		f := rt.Field(x)
+		if !f.IsExported() {
+			continue
+		}
+
+		ft, ok := parsefieldtag(f)
+		if !ok {
+			continue
+		}
+
+		raw, err := unpackfield(j[ft.name])
+		if err != nil {
+			return fmt.Errorf("smartq: field %s: %w", ft.name, err)
+		}
+
+		if raw == "" {
+			continue
+		}
+
+		if err := decodefield(rv.Field(x), raw, ft); err != nil {
+			return fmt.Errorf("smartq: field %s: %w", ft.name, err)
+		}
+	}
+
+	return nil
+}
+
+func decodefield(fv reflect.Value, raw string, ft fieldtag) error {
+	switch fv.Type() {
+	case timeType:
+		t, err := parsetime(raw, ft.ms)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(t))
+		return nil
+	case durationType:
+		d, err := parseduration(raw, ft.ms)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+		return nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetFloat(f)
+	case reflect.Pointer:
+		elem := reflect.New(fv.Type().Elem())
+		if err := decodefield(elem.Elem(), raw, ft); err != nil {
+			return err
+		}
+		fv.Set(elem)
+	default:
+		return decodeobj(raw, fv.Addr().Interface())
+	}
+
+	return nil
+}
+
+func parsetime(raw string, ms bool) (time.Time, error) {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.Parse(time.RFC3339Nano, raw)
+	}
+
+	if ms {
+		return time.UnixMilli(n), nil
+	}
+
+	return time.Unix(n, 0), nil
+}
+
+func parseduration(raw string, ms bool) (time.Duration, error) {
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return time.ParseDuration(raw)
+	}
+
+	if ms {
+		return time.Duration(n) * time.Millisecond, nil
+	}
+
+	return time.Duration(n) * time.Second, nil
+}
+
+// formatduration writes d as whole seconds, or milliseconds with ms. A
+// duration that is not a whole number of them keeps its full precision as a
+// Go duration string, which parseduration also reads.
+func formatduration(d time.Duration, ms bool) string {
+	unit := time.Second
+	if ms {
+		unit = time.Millisecond
+	}
+
+	if d%unit != 0 {
+		return d.String()
+	}
+
+	return strconv.FormatInt(int64(d/unit), 10)
+}
+
+// Encode turns a tagged struct into key/value pairs accepted by InitJob and
+// WatchContext.Route.
+func Encode(v any) ([]any, error) {
+	rv, err := structvalue(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyvals []any
+
+	rt := rv.Type()
+	for x := 0; x < rt.NumField(); x++ {
+		f := rt.Field(x)
+		if !f.IsExported() {
+			continue
+		}
+
+		ft, ok := parsefieldtag(f)
+		if !ok {
+			continue
+		}
+
+		fv := rv.Field(x)
+		if ft.omitempty && fv.IsZero() {
+			continue
+		}
+
+		s, err := encodefield(fv, ft)
+		if err != nil {
+			return nil, fmt.Errorf("smartq: field %s: %w", ft.name, err)
+		}
+
+		keyvals = append(keyvals, ft.name, s)
+	}
+
+	return keyvals, nil
+}
+
+func encodefield(fv reflect.Value, ft fieldtag) (string, error) {
+	switch fv.Type() {
+	case timeType:
+		t := fv.Interface().(time.Time)
+		if t.IsZero() {
+			return "", nil
+		}
+		if ft.ms {
+			return strconv.FormatInt(t.UnixMilli(), 10), nil
+		}
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case durationType:
+		return formatduration(time.Duration(fv.Int()), ft.ms), nil
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		return fv.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(fv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(fv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(fv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(fv.Float(), 'f', -1, fv.Type().Bits()), nil
+	case reflect.Pointer:
+		if fv.IsNil() {
+			return "", nil
+		}
+		return encodefield(fv.Elem(), ft)
+	}
+
+	return encodeobj(codecfor(""), fv.Interface())
+}
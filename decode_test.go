@@ -0,0 +1,174 @@
+package smartq
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testitem struct {
+	SKU string `json:"sku"`
+	Qty int    `json:"qty"`
+}
+
+type testorder struct {
+	ID       string            `smartq:"id"`
+	Amount   int64             `smartq:"amount"`
+	Price    float64           `smartq:"price"`
+	Paid     bool              `smartq:"paid"`
+	Timeout  time.Duration     `smartq:"timeout"`
+	Wait     time.Duration     `smartq:"wait,ms"`
+	Due      time.Time         `smartq:"due"`
+	DueMs    time.Time         `smartq:"due_ms,ms"`
+	Note     *string           `smartq:"note"`
+	Retries  *int              `smartq:"retries"`
+	Items    []testitem        `smartq:"items"`
+	Customer testitem          `smartq:"customer"`
+	Labels   map[string]string `smartq:"labels"`
+	Skipped  string            `smartq:"-"`
+	Untagged string
+}
+
+func encodedjob(t *testing.T, v any) Job {
+	t.Helper()
+
+	keyvals, err := Encode(v)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	job := Job{}
+	for x := 1; x < len(keyvals); x += 2 {
+		job[keyvals[x-1].(string)] = keyvals[x].(string)
+	}
+	return job
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	note := "leave at the door"
+	retries := 3
+
+	tests := []struct {
+		name string
+		in   testorder
+	}{
+		{"empty", testorder{}},
+		{"scalars", testorder{ID: "o1", Amount: -42, Price: 9.99, Paid: true}},
+		{"durations", testorder{Timeout: 90 * time.Second, Wait: 1500 * time.Millisecond}},
+		{"fractional durations", testorder{Timeout: 1500 * time.Millisecond, Wait: 1500 * time.Microsecond}},
+		{"times", testorder{Due: time.Unix(1700000000, 0), DueMs: time.UnixMilli(1700000000123)}},
+		{"pointers", testorder{Note: &note, Retries: &retries}},
+		{"nested", testorder{
+			Items:    []testitem{{"a", 1}, {"b", 2}},
+			Customer: testitem{"c", 3},
+			Labels:   map[string]string{"k": "v"},
+		}},
+		{"untagged", testorder{Untagged: "lowercased name"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out testorder
+			if err := encodedjob(t, tt.in).Decode(&out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !out.Due.Equal(tt.in.Due) || !out.DueMs.Equal(tt.in.DueMs) {
+				t.Fatalf("times: got %v %v, want %v %v", out.Due, out.DueMs, tt.in.Due, tt.in.DueMs)
+			}
+			out.Due, out.DueMs = tt.in.Due, tt.in.DueMs
+
+			if !reflect.DeepEqual(out, tt.in) {
+				t.Fatalf("got %+v, want %+v", out, tt.in)
+			}
+		})
+	}
+}
+
+func TestEncodeSkipsFields(t *testing.T) {
+	job := encodedjob(t, testorder{Skipped: "x", Untagged: "y"})
+
+	if _, ok := job["skipped"]; ok {
+		t.Fatal(`field tagged "-" was encoded`)
+	}
+
+	if job["untagged"] != "y" {
+		t.Fatalf("untagged: got %q", job["untagged"])
+	}
+}
+
+func TestEncodeRawFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    testorder
+		field string
+		want  string
+	}{
+		{"seconds duration", testorder{Timeout: 90 * time.Second}, "timeout", "90"},
+		{"ms duration", testorder{Wait: 1500 * time.Millisecond}, "wait", "1500"},
+		{"fractional seconds duration", testorder{Timeout: 1500 * time.Millisecond}, "timeout", "1.5s"},
+		{"fractional ms duration", testorder{Wait: 1500 * time.Microsecond}, "wait", "1.5ms"},
+		{"negative duration", testorder{Timeout: -time.Minute}, "timeout", "-60"},
+		{"seconds time", testorder{Due: time.UnixMilli(1700000000123)}, "due", "1700000000"},
+		{"ms time", testorder{DueMs: time.UnixMilli(1700000000123)}, "due_ms", "1700000000123"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodedjob(t, tt.in)[tt.field]; got != tt.want {
+				t.Fatalf("%s: got %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRawFields(t *testing.T) {
+	tests := []struct {
+		name  string
+		job   Job
+		check func(testorder) bool
+	}{
+		{"seconds", Job{"due": "1700000000"}, func(o testorder) bool { return o.Due.Equal(time.Unix(1700000000, 0)) }},
+		{"milliseconds", Job{"due_ms": "1700000000123"}, func(o testorder) bool { return o.DueMs.Equal(time.UnixMilli(1700000000123)) }},
+		{"rfc3339", Job{"due": "2023-11-14T22:13:20Z"}, func(o testorder) bool { return o.Due.Equal(time.Unix(1700000000, 0)) }},
+		{"integer duration", Job{"timeout": "30"}, func(o testorder) bool { return o.Timeout == 30*time.Second }},
+		{"integer ms duration", Job{"wait": "250"}, func(o testorder) bool { return o.Wait == 250*time.Millisecond }},
+		{"unmarked json", Job{"customer": `{"sku":"c","qty":3}`}, func(o testorder) bool { return o.Customer == testitem{"c", 3} }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out testorder
+			if err := tt.job.Decode(&out); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+
+			if !tt.check(out) {
+				t.Fatalf("unexpected result %+v", out)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		job  Job
+		v    any
+	}{
+		{"not a pointer", Job{}, testorder{}},
+		{"nil pointer", Job{}, (*testorder)(nil)},
+		{"not a struct", Job{}, new(int)},
+		{"bad int", Job{"amount": "many"}, &testorder{}},
+		{"bad bool", Job{"paid": "maybe"}, &testorder{}},
+		{"bad compressed field", Job{"id": gzipprefix + "!!"}, &testorder{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.job.Decode(tt.v); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
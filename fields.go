@@ -0,0 +1,66 @@
+package smartq
+
+import (
+	"fmt"
+	"slices"
+)
+
+// jobfields encodes key/value pairs of job id with the codec of channel and
+// packs them for writing to redis.
+func jobfields(jobid, channel string, keyvals ...any) ([]string, error) {
+	fields, err := keyvalstostring(codecfor(channel), keyvals...)
+	if err != nil {
+		return nil, err
+	}
+
+	return packfields(jobid, fields)
+}
+
+// packfields prepares encoded key/value pairs of job id for writing to redis.
+// Reserved fields are written unchanged.
+func packfields(jobid string, fields []string) ([]string, error) {
+	packed := make([]string, len(fields))
+	copy(packed, fields)
+
+	for x := 1; x < len(packed); x += 2 {
+		field := packed[x-1]
+
+		//reserved fields are read as is by watchers and admin tools
+		if slices.Contains(reservedfields, field) {
+			continue
+		}
+
+		v, err := compressfield(packed[x])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		v, err = encryptfield(field, v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+
+		v, err = offloadfield(jobid, field, v)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		packed[x] = v
+	}
+
+	return packed, nil
+}
+
+// unpackfield reverses packfields for a single value read from redis.
+func unpackfield(v string) (string, error) {
+	v, err := loadblob(v)
+	if err != nil {
+		return "", err
+	}
+
+	v, err = decryptfield(v)
+	if err != nil {
+		return "", err
+	}
+
+	return decompressfield(v)
+}
@@ -0,0 +1,109 @@
+package smartq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// HealthEvent reports that the redis connection was lost or restored.
+type HealthEvent struct {
+	Healthy bool
+	// Err is the error that marked the connection as lost.
+	Err  error
+	Time time.Time
+}
+
+// health turns the results of redis commands into HealthEvents, emitted
+// only when the state changes.
+type health struct {
+	mu      sync.Mutex
+	healthy bool
+	notify  func(HealthEvent)
+}
+
+func newhealth(notify func(HealthEvent)) *health {
+	return &health{
+		healthy: true,
+		notify:  notify,
+	}
+}
+
+// isconnerr tells connection failures apart from replies: redis.Nil and
+// server errors mean the connection works.
+func isconnerr(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var rediserr redis.Error
+	return !errors.As(err, &rediserr)
+}
+
+func (h *health) observe(err error) {
+	if h == nil {
+		return
+	}
+
+	connerr := isconnerr(err)
+	if err != nil && !connerr {
+		return
+	}
+
+	h.mu.Lock()
+	if h.healthy == !connerr {
+		h.mu.Unlock()
+		return
+	}
+	h.healthy = !connerr
+	h.mu.Unlock()
+
+	event := HealthEvent{Healthy: !connerr, Err: err, Time: time.Now()}
+	if connerr {
+		logger().Warn("redis connection lost", "error", err)
+	} else {
+		logger().Info("redis connection restored")
+		inccounter(metricReconnects, 1)
+	}
+
+	if h.notify != nil {
+		h.notify(event)
+	}
+}
+
+// healthhook feeds every command of a client smartq owns into its health.
+type healthhook struct {
+	h *health
+}
+
+func (hh healthhook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (hh healthhook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	hh.h.observe(cmd.Err())
+	return nil
+}
+
+func (hh healthhook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (hh healthhook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if isconnerr(cmd.Err()) {
+			err = cmd.Err()
+			break
+		}
+	}
+	hh.h.observe(err)
+	return nil
+}
@@ -1,7 +1,6 @@
 package smartq
 
 import (
-	"fmt"
 	"strconv"
 	"time"
 )
@@ -20,24 +19,33 @@ func (j Job) Created() time.Time {
 	return j.Time("created")
 }
 
+// String returns field k, fetching it from the blob store if it was offloaded.
+// The job itself is not changed, so offloaded fields are fetched on every call.
 func (j Job) String(k string) string {
 	v, ok := j[k]
 	if !ok {
 		return ""
 	}
-	return v
+
+	unpacked, err := unpackfield(v)
+	if err != nil {
+		logger().Warn("unable to unpack job field", "job_id", j.ID(), "field", k, "error", err)
+		return ""
+	}
+
+	return unpacked
 }
 
 func (j Job) Int(k string) int {
 	v := j.String(k)
 	if v == "" {
-		fmt.Println("int: v is empty", k)
+		logger().Debug("job field is empty", "job_id", j.ID(), "field", k)
 		return 0
 	}
 
 	i, err := strconv.Atoi(v)
 	if err != nil {
-		fmt.Println("int: unable to aroi", v, err)
+		logger().Warn("job field is not an int", "job_id", j.ID(), "field", k, "value", v, "error", err)
 		return 0
 	}
 	return i
@@ -72,7 +80,6 @@ func (j Job) Bool(k string) bool {
 func (j Job) Time(k string) time.Time {
 	msecs := j.Int(k)
 	if msecs == 0 {
-		fmt.Println("time: msecs is 0")
 		return time.Time{}
 	}
 
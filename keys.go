@@ -0,0 +1,102 @@
+package smartq
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidChannel is returned for channel names with braces, which would
+// change the hash tag of the channel keys.
+var ErrInvalidChannel = errors.New("channel name cannot contain { or }")
+
+func checkchannel(channel string) error {
+	if len(channel) == 0 {
+		return errors.New("channel cannot be empty")
+	}
+
+	if strings.ContainsAny(channel, "{}") {
+		return ErrInvalidChannel
+	}
+
+	return nil
+}
+
+// keyspace names every redis key and bbolt bucket smartq uses. A namespace
+// prefixes all of them, so several environments or applications can share
+// one redis.
+//
+// Keys carry a Redis Cluster hash tag: every key of a channel hashes on
+// {channel} and a job hash on {id}, so the operations on one channel stay on
+// one slot.
+type keyspace struct {
+	namespace string
+	prefix    string
+}
+
+func newkeyspace(namespace string) keyspace {
+	if len(namespace) == 0 {
+		return keyspace{}
+	}
+	return keyspace{namespace: namespace, prefix: namespace + ":"}
+}
+
+func (k keyspace) channels() string {
+	return k.prefix + channelsKey
+}
+
+func (k keyspace) watches() string {
+	return k.prefix + watchesKey
+}
+
+func (k keyspace) store() string {
+	return k.prefix + storeKey
+}
+
+// storeworking holds the store commands being handled. Its hash tag is the
+// name of the command list, so both stay on one cluster slot.
+func (k keyspace) storeworking() string {
+	return "{" + k.store() + "}working"
+}
+
+func (k keyspace) channel(channel string) string {
+	return fmt.Sprintf("%ssq_channel_{%s}", k.prefix, channel)
+}
+
+func (k keyspace) status(channel string) string {
+	return fmt.Sprintf("%ssq_channel_status_{%s}", k.prefix, channel)
+}
+
+func (k keyspace) scheduled(channel string) string {
+	return fmt.Sprintf("%ssq_scheduled_{%s}", k.prefix, channel)
+}
+
+func (k keyspace) latency(channel string) string {
+	return fmt.Sprintf("%ssq_channel_latency_{%s}", k.prefix, channel)
+}
+
+func (k keyspace) workingset(channel string) string {
+	return fmt.Sprintf("%sworkingset_{%s}", k.prefix, channel)
+}
+
+// watcherset lists the jobs of the channel working set that watch took, so
+// a restarted watcher can put them back on the channel.
+func (k keyspace) watcherset(channel, watch string) string {
+	return fmt.Sprintf("%sworkingset_{%s}_%s", k.prefix, channel, watch)
+}
+
+func (k keyspace) job(id string) string {
+	return fmt.Sprintf("%ssq_job_{%s}", k.prefix, id)
+}
+
+func (k keyspace) unique(channel, key string) string {
+	return fmt.Sprintf("%ssq_unique_{%s}_%s", k.prefix, channel, key)
+}
+
+func (k keyspace) bucket() string {
+	return k.prefix + defautBucket
+}
+
+func (k keyspace) blobbucket() string {
+	return k.prefix + blobBucket
+}
@@ -0,0 +1,78 @@
+package smartq
+
+import (
+	"strings"
+	"testing"
+)
+
+// hashtag returns the part of key Redis Cluster hashes on.
+func hashtag(key string) string {
+	start := strings.Index(key, "{")
+	if start < 0 {
+		return key
+	}
+
+	end := strings.Index(key[start+1:], "}")
+	if end <= 0 {
+		return key
+	}
+
+	return key[start+1 : start+1+end]
+}
+
+func TestCheckChannel(t *testing.T) {
+	tests := []struct {
+		channel string
+		err     bool
+	}{
+		{"orders", false},
+		{"orders.eu-west_1", false},
+		{"orders:priority", false},
+		{"", true},
+		{"{orders}", true},
+		{"orders}", true},
+		{"{orders", true},
+		{"a{b}c", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.channel, func(t *testing.T) {
+			if err := checkchannel(tt.channel); (err != nil) != tt.err {
+				t.Fatalf("error: got %v, want error %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestChannelKeysShareHashTag(t *testing.T) {
+	tests := []struct {
+		namespace string
+		channel   string
+	}{
+		{"", "orders"},
+		{"staging", "orders"},
+		{"", "orders:priority"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.namespace+"/"+tt.channel, func(t *testing.T) {
+			k := newkeyspace(tt.namespace)
+
+			keys := []string{
+				k.channel(tt.channel),
+				k.status(tt.channel),
+				k.scheduled(tt.channel),
+				k.latency(tt.channel),
+				k.workingset(tt.channel),
+				k.watcherset(tt.channel, "w1"),
+				k.unique(tt.channel, "key"),
+			}
+
+			for _, key := range keys {
+				if got := hashtag(key); got != tt.channel {
+					t.Fatalf("%s: hash tag %q, want %q", key, got, tt.channel)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package smartq
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// The library logs through an slog.Logger that discards everything until the
+// application sets one with SetLogger.
+
+var currentlogger atomic.Pointer[slog.Logger]
+
+func init() {
+	SetLogger(nil)
+}
+
+// SetLogger sets the logger used for all smartq diagnostics. Jobs, channels
+// and watches are logged with the job_id, channel and watch attributes.
+// A nil logger silences the library again.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	currentlogger.Store(l)
+}
+
+func logger() *slog.Logger {
+	return currentlogger.Load()
+}
@@ -1,8 +1,8 @@
 package smartq
 
 import (
-	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"time"
 
@@ -15,6 +15,7 @@ const synccommand = "sync"
 const newcommand = "new"
 
 var channelsKey = "sq_channels"
+var watchesKey = "sq_watches"
 
 const defautBucket = "__container__"
 
@@ -22,23 +23,12 @@ const defautBucket = "__container__"
 // const storeSyncKey = "sq_store_sync"
 const storeKey = "sq_store_"
 const storePrintCommand = "print"
+const storeEmptyCommand = "empty"
 
 func ID() string {
 	return strings.ReplaceAll(uuid.NewString(), "-", "")
 }
 
-func channelKey(channel string) string {
-	return fmt.Sprintf("sq_channel_%s", channel)
-}
-
-func channelStatusKey(channel string) string {
-	return fmt.Sprintf("sq_channel_status_%s", channel)
-}
-
-func jobKey(jobid string) string {
-	return fmt.Sprintf("sq_job_%s", jobid)
-}
-
 func icc(id, channel, command string) string {
 	return fmt.Sprintf("%s|%s|%s", id, channel, command)
 }
@@ -50,7 +40,7 @@ func iccparse(i string) (id, channel, command string) {
 	}
 
 	if len(splitted) < 3 {
-		fmt.Println("job is moved to trash", i)
+		logger().Warn("malformed command, job is moved to trash", "command", i)
 		return "trash_job", "trash_channel", ""
 	}
 
@@ -61,13 +51,13 @@ func iccparse(i string) (id, channel, command string) {
 	return id, channel, command
 }
 
-func keyvalstostring(keyvals ...any) []string {
+func keyvalstostring(codec Codec, keyvals ...any) ([]string, error) {
 	var result []string
 	for x := 1; x < len(keyvals); x += 2 {
 		result = append(result, keyvals[x-1].(string))
 		switch v := keyvals[x].(type) {
 		case string:
-			result = append(result, fmt.Sprintf("%s", v))
+			result = append(result, v)
 		case int:
 			result = append(result, fmt.Sprintf("%d", v))
 		case float64:
@@ -76,15 +66,20 @@ func keyvalstostring(keyvals ...any) []string {
 			result = append(result, fmt.Sprintf("%t", v))
 		case time.Time:
 			result = append(result, fmt.Sprintf("%d", v.Unix()))
-		case *any:
-			jsoned, _ := json.Marshal(v)
-			result = append(result, string(jsoned))
-		case any:
-			jsoned, _ := json.Marshal(v)
-			result = append(result, string(jsoned))
+		case time.Duration:
+			result = append(result, v.String())
 		default:
-			result = append(result, fmt.Sprintf("%v", v))
+			switch reflect.ValueOf(v).Kind() {
+			case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct, reflect.Pointer, reflect.Interface:
+				encoded, err := encodeobj(codec, v)
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", keyvals[x-1], err)
+				}
+				result = append(result, encoded)
+			default:
+				result = append(result, fmt.Sprintf("%v", v))
+			}
 		}
 	}
-	return result
+	return result, nil
 }
@@ -0,0 +1,233 @@
+package smartq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Metrics are kept in process and written in the Prometheus text format by
+// MetricsHandler, MetricsHandlerWithOptions, Client.MetricsHandler,
+// Watch.ServeMetrics and the store's /metrics endpoint.
+// Queue depth and store sync lag are read from redis on every scrape.
+
+const (
+	metricEnqueued   = "smartq_jobs_enqueued_total"
+	metricRouted     = "smartq_jobs_routed_total"
+	metricDeleted    = "smartq_jobs_deleted_total"
+	metricFailed     = "smartq_jobs_failed_total"
+	metricReconnects = "smartq_redis_reconnects_total"
+	metricHandler    = "smartq_handler_duration_seconds"
+)
+
+var metrichelp = map[string]string{
+	metricEnqueued:   "Jobs enqueued by this process.",
+	metricRouted:     "Jobs routed to a channel by this process.",
+	metricDeleted:    "Jobs deleted by this process.",
+	metricFailed:     "Handler results that could not be applied; the job stays in the working set.",
+	metricReconnects: "Times the redis connection was re-established.",
+	metricHandler:    "Time spent in watch handlers.",
+}
+
+var handlerbuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+var metricsmu sync.Mutex
+var counters = map[string]map[string]float64{}
+var histograms = map[string]map[string]*histogram{}
+
+func labels(kv ...string) string {
+	var parts []string
+	for x := 1; x < len(kv); x += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[x])
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, kv[x-1], v))
+	}
+	return strings.Join(parts, ",")
+}
+
+func inccounter(name string, n int64, kv ...string) {
+	metricsmu.Lock()
+	defer metricsmu.Unlock()
+
+	if counters[name] == nil {
+		counters[name] = map[string]float64{}
+	}
+	counters[name][labels(kv...)] += float64(n)
+}
+
+func countjobs(name, channel string, n int64) {
+	if n > 0 {
+		inccounter(name, n, "channel", channel)
+	}
+}
+
+func observehandler(watch, channel string, took time.Duration) {
+	metricsmu.Lock()
+	defer metricsmu.Unlock()
+
+	if histograms[metricHandler] == nil {
+		histograms[metricHandler] = map[string]*histogram{}
+	}
+
+	key := labels("watch", watch, "channel", channel)
+	h, ok := histograms[metricHandler][key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(handlerbuckets))}
+		histograms[metricHandler][key] = h
+	}
+
+	seconds := took.Seconds()
+	for x, le := range handlerbuckets {
+		if seconds <= le {
+			h.counts[x]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+func writeheader(w io.Writer, name, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, metrichelp[name], name, kind)
+}
+
+func sample(w io.Writer, name, lbls string, v float64) {
+	if len(lbls) > 0 {
+		fmt.Fprintf(w, "%s{%s} %g\n", name, lbls, v)
+		return
+	}
+	fmt.Fprintf(w, "%s %g\n", name, v)
+}
+
+func sortedkeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeprocessmetrics(w io.Writer) {
+	metricsmu.Lock()
+	defer metricsmu.Unlock()
+
+	for _, name := range sortedkeys(counters) {
+		writeheader(w, name, "counter")
+		for _, lbls := range sortedkeys(counters[name]) {
+			sample(w, name, lbls, counters[name][lbls])
+		}
+	}
+
+	for _, name := range sortedkeys(histograms) {
+		writeheader(w, name, "histogram")
+		for _, lbls := range sortedkeys(histograms[name]) {
+			h := histograms[name][lbls]
+			for x, le := range handlerbuckets {
+				sample(w, name+"_bucket", lbls+fmt.Sprintf(`,le="%g"`, le), float64(h.counts[x]))
+			}
+			sample(w, name+"_bucket", lbls+`,le="+Inf"`, float64(h.count))
+			sample(w, name+"_sum", lbls, h.sum)
+			sample(w, name+"_count", lbls, float64(h.count))
+		}
+	}
+}
+
+func writeredismetrics(w io.Writer, r *repo) error {
+	c := r.R()
+	if c == nil {
+		return fmt.Errorf("connection to redis is nil: how?")
+	}
+
+	channels, err := c.ZRange(context.Background(), r.keys.channels(), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := c.Pipeline()
+	depths := make([]*redis.IntCmd, len(channels))
+	inflight := make([]*redis.IntCmd, len(channels))
+	for x, channel := range channels {
+		depths[x] = pipe.ZCard(context.Background(), r.keys.channel(channel))
+		inflight[x] = pipe.LLen(context.Background(), r.keys.workingset(channel))
+	}
+	lag := pipe.LLen(context.Background(), r.keys.store())
+	if _, err := pipe.Exec(context.Background()); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "# HELP smartq_channel_depth Jobs waiting on the channel.\n# TYPE smartq_channel_depth gauge\n")
+	for x, channel := range channels {
+		sample(w, "smartq_channel_depth", labels("channel", channel), float64(depths[x].Val()))
+	}
+
+	fmt.Fprintf(w, "# HELP smartq_channel_inflight Jobs in the working set of the channel.\n# TYPE smartq_channel_inflight gauge\n")
+	for x, channel := range channels {
+		sample(w, "smartq_channel_inflight", labels("channel", channel), float64(inflight[x].Val()))
+	}
+
+	fmt.Fprintf(w, "# HELP smartq_store_sync_lag Commands waiting for the store.\n# TYPE smartq_store_sync_lag gauge\n")
+	sample(w, "smartq_store_sync_lag", "", float64(lag.Val()))
+
+	return nil
+}
+
+func writemetrics(w io.Writer, r *repo) {
+	var buf bytes.Buffer
+	writeprocessmetrics(&buf)
+
+	if err := writeredismetrics(&buf, r); err != nil {
+		fmt.Fprintf(&buf, "# redis metrics unavailable: %s\n", strings.ReplaceAll(err.Error(), "\n", " "))
+	}
+
+	w.Write(buf.Bytes())
+}
+
+func metricshandler(r *repo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writemetrics(w, r)
+	})
+}
+
+// MetricsHandler serves the metrics of this process in the Prometheus text format.
+func MetricsHandler() http.Handler {
+	return metricshandler(getcachedrepo())
+}
+
+// MetricsHandlerWithOptions is MetricsHandler reading queue depth and store
+// lag through a connection made from opts. With opts.Client set it shares
+// that connection.
+func MetricsHandlerWithOptions(opts Options) (http.Handler, error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return metricshandler(r), nil
+}
+
+// MetricsHandler is MetricsHandler on the client's connection.
+func (c *Client) MetricsHandler() http.Handler {
+	return metricshandler(c.r)
+}
+
+// ServeMetrics serves /metrics on addr using the watch's connection. It blocks
+// like http.ListenAndServe, so run it in its own goroutine.
+func (w *Watch) ServeMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricshandler(w.r))
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,192 @@
+package smartq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// legacyprefixes are the key prefixes used before keys carried hash tags,
+// longest first so status and latency keys are not read as channel keys.
+var legacyprefixes = []string{
+	"sq_channel_status_",
+	"sq_channel_latency_",
+	"sq_channel_",
+	"sq_scheduled_",
+	"workingset_",
+	"sq_job_",
+}
+
+// legacykey returns the hash tagged name of a key written by an older
+// version, or false when key is not one.
+func legacykey(key string) (string, bool) {
+	for _, prefix := range legacyprefixes {
+		rest, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+
+		if len(rest) == 0 || strings.HasPrefix(rest, "{") {
+			return "", false
+		}
+
+		return prefix + "{" + rest + "}", true
+	}
+
+	return "", false
+}
+
+// migratedkey returns the name an un-namespaced key has in k, in the hash
+// tagged layout, or false when it already has that name.
+func (k keyspace) migratedkey(key string) (string, bool) {
+	if tagged, ok := legacykey(key); ok {
+		return k.prefix + tagged, true
+	}
+
+	if len(k.prefix) == 0 {
+		return "", false
+	}
+
+	return k.prefix + key, true
+}
+
+// Namespace is the namespace of the admin's keys.
+func (a *Admin) Namespace() string {
+	return a.r.keys.namespace
+}
+
+// MigrateLegacyKeys renames the keys written without a namespace, or before
+// keys carried cluster hash tags, to their names in the admin's namespace and
+// returns how many were renamed. Run it with watchers, producers and the store
+// stopped, on a standalone instance, before moving the data to a cluster.
+// Keys whose new name is already taken are left alone and logged.
+// Uniqueness keys are not migrated; they expire on their own.
+func (a *Admin) MigrateLegacyKeys(ctx context.Context) (int, error) {
+	c := a.r.R()
+	if c == nil {
+		return 0, errors.New("connection to redis is nil: how?")
+	}
+
+	k := a.r.keys
+
+	var renamed int
+	rename := func(key, newkey string) error {
+		done, err := c.RenameNX(ctx, key, newkey).Result()
+		if err != nil {
+			return err
+		}
+
+		if !done {
+			logger().Warn("key already migrated, leaving legacy key in place", "key", key, "new_key", newkey)
+			return nil
+		}
+
+		renamed++
+		return nil
+	}
+
+	if len(k.prefix) > 0 {
+		legacy := keyspace{}
+		for _, key := range []string{channelsKey, watchesKey, storeKey, legacy.storeworking()} {
+			n, err := c.Exists(ctx, key).Result()
+			if err != nil {
+				return renamed, err
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			newkey := k.prefix + key
+			if key == legacy.storeworking() {
+				newkey = k.storeworking()
+			}
+
+			if err := rename(key, newkey); err != nil {
+				return renamed, err
+			}
+		}
+	}
+
+	for _, pattern := range []string{"sq_channel_*", "sq_scheduled_*", "workingset_*", "sq_job_*"} {
+		iter := c.Scan(ctx, 0, pattern, 500).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			newkey, ok := k.migratedkey(key)
+			if !ok {
+				continue
+			}
+
+			if err := rename(key, newkey); err != nil {
+				return renamed, err
+			}
+		}
+
+		if err := iter.Err(); err != nil {
+			return renamed, err
+		}
+	}
+
+	return renamed, nil
+}
+
+// MigrateStoreBuckets moves the job copies and blobs of a store file written
+// without a namespace into the buckets of namespace, and returns how many
+// entries were moved. The store must be stopped. Entries already present in
+// the namespaced buckets are kept.
+func MigrateStoreBuckets(filepath, namespace string) (int, error) {
+	if len(namespace) == 0 {
+		return 0, errors.New("namespace cannot be empty")
+	}
+
+	db, err := bbolt.Open(filepath, 0664, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	k := newkeyspace(namespace)
+
+	var moved int
+	err = db.Update(func(tx *bbolt.Tx) error {
+		buckets := map[string]string{
+			defautBucket: k.bucket(),
+			blobBucket:   k.blobbucket(),
+		}
+
+		for from, to := range buckets {
+			source := tx.Bucket([]byte(from))
+			if source == nil {
+				continue
+			}
+
+			target, err := tx.CreateBucketIfNotExists([]byte(to))
+			if err != nil {
+				return err
+			}
+
+			err = source.ForEach(func(key, value []byte) error {
+				if target.Get(key) != nil {
+					return nil
+				}
+
+				moved++
+				return target.Put(key, value)
+			})
+			if err != nil {
+				return err
+			}
+
+			if err := tx.DeleteBucket([]byte(from)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return moved, err
+}
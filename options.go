@@ -0,0 +1,376 @@
+package smartq
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+)
+
+// Options configures the redis connection of a Watch, store, Admin or
+// producer. Use DefaultOptions, OptionsFromEnv or OptionsFromFile to get one.
+type Options struct {
+	// Client is an existing connection to use instead of dialing one; the
+	// connection fields are ignored when it is set. smartq never closes it.
+	Client redis.UniversalClient
+
+	// Namespace prefixes every key and store bucket, so several environments
+	// or applications can share one redis.
+	Namespace string
+
+	Addr string
+	// Addrs are the seed nodes of a Redis Cluster; when set Addr is ignored.
+	Addrs []string
+	// MasterName and SentinelAddrs connect through Redis Sentinel, which
+	// follows the master across failovers; when set Addr is ignored.
+	MasterName       string
+	SentinelAddrs    []string
+	SentinelPassword string
+
+	Username string
+	Password string
+	DB       int
+
+	// TLS enables TLS with the default settings; TLSConfig overrides them.
+	TLS       bool
+	TLSConfig *tls.Config
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolSize     int
+
+	// ConnectRetries is how many times the first connection is retried, 3 by
+	// default; ConnectTimeout bounds all attempts, 10s by default.
+	ConnectRetries int
+	ConnectTimeout time.Duration
+
+	// OperationTimeout bounds each redis operation, 5s by default. Blocking
+	// reads get it on top of their own wait.
+	OperationTimeout time.Duration
+
+	// OnHealth is called when the connection is lost and when it comes back.
+	OnHealth func(HealthEvent)
+}
+
+// optionsfile is the layout of json and yaml config files. The legacy "url"
+// key of LoadConfig files is accepted as an alias of "addr".
+type optionsfile struct {
+	Namespace        string   `json:"namespace" yaml:"namespace"`
+	Addr             string   `json:"addr" yaml:"addr"`
+	Addrs            []string `json:"addrs" yaml:"addrs"`
+	Url              string   `json:"url" yaml:"url"`
+	MasterName       string   `json:"master_name" yaml:"master_name"`
+	SentinelAddrs    []string `json:"sentinel_addrs" yaml:"sentinel_addrs"`
+	SentinelPassword string   `json:"sentinel_password" yaml:"sentinel_password"`
+	Username         string   `json:"username" yaml:"username"`
+	Password         string   `json:"password" yaml:"password"`
+	DB               int      `json:"db" yaml:"db"`
+	TLS              bool     `json:"tls" yaml:"tls"`
+	DialTimeout      string   `json:"dial_timeout" yaml:"dial_timeout"`
+	ReadTimeout      string   `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout     string   `json:"write_timeout" yaml:"write_timeout"`
+	PoolSize         int      `json:"pool_size" yaml:"pool_size"`
+	ConnectRetries   int      `json:"connect_retries" yaml:"connect_retries"`
+	ConnectTimeout   string   `json:"connect_timeout" yaml:"connect_timeout"`
+	OperationTimeout string   `json:"operation_timeout" yaml:"operation_timeout"`
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Addr:     defaulturl,
+		Password: defaultpassword,
+	}
+}
+
+// OptionsFromEnv reads SMARTQ_NAMESPACE, SMARTQ_REDIS_ADDR,
+// SMARTQ_REDIS_ADDRS (comma separated cluster nodes), SMARTQ_SENTINEL_MASTER,
+// SMARTQ_SENTINEL_ADDRS (comma separated), SMARTQ_SENTINEL_PASSWORD,
+// SMARTQ_REDIS_USERNAME,
+// SMARTQ_REDIS_PASSWORD, SMARTQ_REDIS_DB, SMARTQ_REDIS_TLS,
+// SMARTQ_DIAL_TIMEOUT, SMARTQ_READ_TIMEOUT, SMARTQ_WRITE_TIMEOUT,
+// SMARTQ_CONNECT_RETRIES, SMARTQ_CONNECT_TIMEOUT, SMARTQ_OPERATION_TIMEOUT and
+// SMARTQ_POOL_SIZE on top of the defaults.
+func OptionsFromEnv() (Options, error) {
+	opts := DefaultOptions()
+
+	if v, ok := os.LookupEnv("SMARTQ_REDIS_ADDR"); ok {
+		opts.Addr = v
+	}
+
+	if v := os.Getenv("SMARTQ_REDIS_ADDRS"); len(v) > 0 {
+		opts.Addrs = strings.Split(v, ",")
+	}
+
+	if v, ok := os.LookupEnv("SMARTQ_NAMESPACE"); ok {
+		opts.Namespace = v
+	}
+
+	if v, ok := os.LookupEnv("SMARTQ_SENTINEL_MASTER"); ok {
+		opts.MasterName = v
+	}
+
+	if v := os.Getenv("SMARTQ_SENTINEL_ADDRS"); len(v) > 0 {
+		opts.SentinelAddrs = strings.Split(v, ",")
+	}
+
+	if v, ok := os.LookupEnv("SMARTQ_SENTINEL_PASSWORD"); ok {
+		opts.SentinelPassword = v
+	}
+
+	if v, ok := os.LookupEnv("SMARTQ_REDIS_USERNAME"); ok {
+		opts.Username = v
+	}
+
+	if v, ok := os.LookupEnv("SMARTQ_REDIS_PASSWORD"); ok {
+		opts.Password = v
+	}
+
+	var err error
+	if v := os.Getenv("SMARTQ_REDIS_DB"); len(v) > 0 {
+		if opts.DB, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("SMARTQ_REDIS_DB: %w", err)
+		}
+	}
+
+	if v := os.Getenv("SMARTQ_REDIS_TLS"); len(v) > 0 {
+		if opts.TLS, err = strconv.ParseBool(v); err != nil {
+			return opts, fmt.Errorf("SMARTQ_REDIS_TLS: %w", err)
+		}
+	}
+
+	if v := os.Getenv("SMARTQ_CONNECT_RETRIES"); len(v) > 0 {
+		if opts.ConnectRetries, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("SMARTQ_CONNECT_RETRIES: %w", err)
+		}
+	}
+
+	if v := os.Getenv("SMARTQ_POOL_SIZE"); len(v) > 0 {
+		if opts.PoolSize, err = strconv.Atoi(v); err != nil {
+			return opts, fmt.Errorf("SMARTQ_POOL_SIZE: %w", err)
+		}
+	}
+
+	timeouts := map[string]*time.Duration{
+		"SMARTQ_DIAL_TIMEOUT":      &opts.DialTimeout,
+		"SMARTQ_READ_TIMEOUT":      &opts.ReadTimeout,
+		"SMARTQ_WRITE_TIMEOUT":     &opts.WriteTimeout,
+		"SMARTQ_CONNECT_TIMEOUT":   &opts.ConnectTimeout,
+		"SMARTQ_OPERATION_TIMEOUT": &opts.OperationTimeout,
+	}
+	for name, d := range timeouts {
+		if v := os.Getenv(name); len(v) > 0 {
+			if *d, err = time.ParseDuration(v); err != nil {
+				return opts, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+// OptionsFromFile reads a .json, .yaml or .yml config file. Missing keys keep
+// their default values.
+func OptionsFromFile(filename string) (Options, error) {
+	opts := DefaultOptions()
+
+	bytes, err := os.ReadFile(filename)
+	if err != nil {
+		return opts, err
+	}
+
+	var file optionsfile
+	switch filepath.Ext(filename) {
+	case ".json":
+		err = json.Unmarshal(bytes, &file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(bytes, &file)
+	default:
+		return opts, fmt.Errorf("unsupported config file type: %s", filename)
+	}
+
+	if err != nil {
+		return opts, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if len(file.Url) > 0 {
+		opts.Addr = file.Url
+	}
+
+	if len(file.Addr) > 0 {
+		opts.Addr = file.Addr
+	}
+
+	if len(file.Password) > 0 {
+		opts.Password = file.Password
+	}
+
+	opts.Namespace = file.Namespace
+	opts.Addrs = file.Addrs
+	opts.MasterName = file.MasterName
+	opts.SentinelAddrs = file.SentinelAddrs
+	opts.SentinelPassword = file.SentinelPassword
+	opts.Username = file.Username
+	opts.DB = file.DB
+	opts.TLS = file.TLS
+	opts.PoolSize = file.PoolSize
+	opts.ConnectRetries = file.ConnectRetries
+
+	timeouts := map[string]struct {
+		v string
+		d *time.Duration
+	}{
+		"dial_timeout":      {file.DialTimeout, &opts.DialTimeout},
+		"read_timeout":      {file.ReadTimeout, &opts.ReadTimeout},
+		"write_timeout":     {file.WriteTimeout, &opts.WriteTimeout},
+		"connect_timeout":   {file.ConnectTimeout, &opts.ConnectTimeout},
+		"operation_timeout": {file.OperationTimeout, &opts.OperationTimeout},
+	}
+	for name, t := range timeouts {
+		if len(t.v) > 0 {
+			if *t.d, err = time.ParseDuration(t.v); err != nil {
+				return opts, fmt.Errorf("%s: %s: %w", filename, name, err)
+			}
+		}
+	}
+
+	return opts, opts.Validate()
+}
+
+func (o Options) Validate() error {
+	//the namespace ends up in hash tagged cluster keys
+	if strings.ContainsAny(o.Namespace, "{}") {
+		return errors.New("namespace cannot contain braces")
+	}
+
+	if o.Client != nil {
+		return nil
+	}
+
+	if len(o.Addr) == 0 && len(o.Addrs) == 0 && len(o.MasterName) == 0 {
+		return errors.New("redis address cannot be empty")
+	}
+
+	if len(o.MasterName) > 0 && len(o.SentinelAddrs) == 0 {
+		return errors.New("sentinel addresses must be set with a master name")
+	}
+
+	if len(o.MasterName) > 0 && len(o.Addrs) > 0 {
+		return errors.New("sentinel and cluster addresses cannot be combined")
+	}
+
+	if len(o.Addrs) > 0 && o.DB != 0 {
+		return errors.New("redis cluster only supports db 0")
+	}
+
+	if o.DB < 0 {
+		return errors.New("redis db cannot be negative")
+	}
+
+	if o.PoolSize < 0 {
+		return errors.New("pool size cannot be negative")
+	}
+
+	if o.ConnectRetries < 0 {
+		return errors.New("connect retries cannot be negative")
+	}
+
+	if o.DialTimeout < 0 || o.ReadTimeout < 0 || o.WriteTimeout < 0 || o.ConnectTimeout < 0 || o.OperationTimeout < 0 {
+		return errors.New("timeouts cannot be negative")
+	}
+
+	return nil
+}
+
+func (o Options) redisoptions() *redis.Options {
+	ro := &redis.Options{
+		Addr:         o.Addr,
+		Username:     o.Username,
+		Password:     o.Password,
+		DB:           o.DB,
+		DialTimeout:  o.DialTimeout,
+		ReadTimeout:  o.ReadTimeout,
+		WriteTimeout: o.WriteTimeout,
+		PoolSize:     o.PoolSize,
+		TLSConfig:    o.TLSConfig,
+	}
+
+	if o.TLS && ro.TLSConfig == nil {
+		ro.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return ro
+}
+
+func (o Options) clusteroptions() *redis.ClusterOptions {
+	ro := o.redisoptions()
+
+	return &redis.ClusterOptions{
+		Addrs:        o.Addrs,
+		Username:     ro.Username,
+		Password:     ro.Password,
+		DialTimeout:  ro.DialTimeout,
+		ReadTimeout:  ro.ReadTimeout,
+		WriteTimeout: ro.WriteTimeout,
+		PoolSize:     ro.PoolSize,
+		TLSConfig:    ro.TLSConfig,
+	}
+}
+
+func (o Options) failoveroptions() *redis.FailoverOptions {
+	ro := o.redisoptions()
+
+	return &redis.FailoverOptions{
+		MasterName:       o.MasterName,
+		SentinelAddrs:    o.SentinelAddrs,
+		SentinelPassword: o.SentinelPassword,
+		Username:         ro.Username,
+		Password:         ro.Password,
+		DB:               ro.DB,
+		DialTimeout:      ro.DialTimeout,
+		ReadTimeout:      ro.ReadTimeout,
+		WriteTimeout:     ro.WriteTimeout,
+		PoolSize:         ro.PoolSize,
+		TLSConfig:        ro.TLSConfig,
+	}
+}
+
+func (o Options) newclient() redis.UniversalClient {
+	if len(o.MasterName) > 0 {
+		return redis.NewFailoverClient(o.failoveroptions())
+	}
+
+	if len(o.Addrs) > 0 {
+		return redis.NewClusterClient(o.clusteroptions())
+	}
+	return redis.NewClient(o.redisoptions())
+}
+
+func (o Options) connectretries() int {
+	if o.ConnectRetries == 0 {
+		return 3
+	}
+	return o.ConnectRetries
+}
+
+func (o Options) connecttimeout() time.Duration {
+	if o.ConnectTimeout == 0 {
+		return 10 * time.Second
+	}
+	return o.ConnectTimeout
+}
+
+func (o Options) operationtimeout() time.Duration {
+	if o.OperationTimeout == 0 {
+		return 5 * time.Second
+	}
+	return o.OperationTimeout
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -11,33 +14,123 @@ import (
 
 var ErrChannelPaused = errors.New("channel is paused")
 
-func newconn() *redis.Client {
-tryagain:
+// ErrChannelDraining is returned when enqueueing to a draining channel. Routes
+// from other channels are still accepted; see Admin.DrainChannel.
+var ErrChannelDraining = errors.New("channel is draining and does not accept new jobs")
 
-	newconn := redis.NewClient(&redis.Options{
-		Addr:     redisurl,
-		Password: redispassword, // no password set
-		DB:       0,             // use default DB
-	})
+// connect pings a new client until it answers, backing off between at most
+// opts.ConnectRetries retries and giving up at the ConnectTimeout deadline.
+// Once connected the client's pool redials on its own.
+func connect(opts Options, h *health) (redis.UniversalClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.connecttimeout())
+	defer cancel()
+
+	conn := opts.newclient()
+	conn.AddHook(healthhook{h})
+
+	b := newbackoff()
+
+	var err error
+	for attempt := 0; attempt <= opts.connectretries(); attempt++ {
+		if attempt > 0 {
+			logger().Warn("unable to connect to redis, retrying", "attempt", attempt, "error", err)
+
+			select {
+			case <-ctx.Done():
+				conn.Close()
+				return nil, err
+			case <-time.After(b.next()):
+			}
+		}
 
-	if err := newconn.Ping(context.Background()).Err(); err != nil {
-		time.Sleep(1 * time.Second)
-		goto tryagain
+		if err = conn.Ping(ctx).Err(); err == nil {
+			return conn, nil
+		}
 	}
 
-	return newconn
+	conn.Close()
+	return nil, err
 }
 
+// newrepo uses the settings of LoadConfig. It does not wait for redis: the
+// first operations return the connection error if it is down.
 func newrepo() *repo {
+	if loadedoptions.Client != nil {
+		return sharedrepo(loadedoptions)
+	}
+
+	h := newhealth(loadedoptions.OnHealth)
+
+	conn := loadedoptions.newclient()
+	conn.AddHook(healthhook{h})
+
 	return &repo{
-		conn:    newconn(),
-		timeout: time.Second,
+		conn:      conn,
+		health:    h,
+		keys:      newkeyspace(loadedoptions.Namespace),
+		timeout:   time.Second,
+		optimeout: loadedoptions.operationtimeout(),
+	}
+}
+
+// newrepowithoptions validates opts and connects, returning the error once
+// the retries run out. A client given in opts is used as is.
+func newrepowithoptions(opts Options) (*repo, error) {
+	if opts.Client != nil {
+		if err := opts.Validate(); err != nil {
+			return nil, err
+		}
+
+		return sharedrepo(opts), nil
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	h := newhealth(opts.OnHealth)
+
+	conn, err := connect(opts, h)
+	if err != nil {
+		return nil, err
 	}
+
+	return &repo{
+		conn:      conn,
+		health:    h,
+		keys:      newkeyspace(opts.Namespace),
+		timeout:   time.Second,
+		optimeout: opts.operationtimeout(),
+	}, nil
+}
+
+// newrepowithclient uses a client owned by the caller: the repo never
+// closes it or adds hooks to it.
+func newrepowithclient(conn redis.UniversalClient) *repo {
+	return &repo{
+		conn:      conn,
+		shared:    true,
+		timeout:   time.Second,
+		optimeout: Options{}.operationtimeout(),
+	}
+}
+
+// sharedrepo uses opts.Client with the other settings of opts.
+func sharedrepo(opts Options) *repo {
+	r := newrepowithclient(opts.Client)
+	r.health = newhealth(opts.OnHealth)
+	r.keys = newkeyspace(opts.Namespace)
+	r.optimeout = opts.operationtimeout()
+	return r
 }
 
 var cachedrepo *repo
+var cachedrepolock sync.Mutex
 
 func getcachedrepo() *repo {
+	cachedrepolock.Lock()
+	defer cachedrepolock.Unlock()
+
 	if cachedrepo == nil {
 		cachedrepo = newrepo()
 	}
@@ -45,12 +138,22 @@ func getcachedrepo() *repo {
 }
 
 type repo struct {
-	conn    *redis.Client
+	conn    redis.UniversalClient
+	health  *health
+	keys    keyspace
+	shared  bool
 	timeout time.Duration
+	// optimeout bounds each operation, see Options.OperationTimeout.
+	optimeout time.Duration
+}
+
+// opcontext bounds one operation by the operation timeout.
+func (r *repo) opcontext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), r.optimeout)
 }
 
 func (r *repo) Close() error {
-	if r.conn == nil {
+	if r.conn == nil || r.shared {
 		return nil
 	}
 
@@ -61,35 +164,34 @@ func (r *repo) Close() error {
 	return nil
 }
 
-func (r *repo) R() *redis.Client {
-	if err := r.conn.Ping(context.Background()).Err(); err != nil {
-		return newconn()
-	}
-
+// R returns the client; its pool replaces broken connections, so commands
+// fail with the connection error instead of being retried here.
+func (r *repo) R() redis.UniversalClient {
 	return r.conn
 }
 
-func (r *repo) loadobjectfromhash(key string) map[string]string {
+func (r *repo) loadobjectfromhash(key string) (map[string]string, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
-		return nil
+		return nil, errors.New("redis is nil. How?")
 	}
 
-	if v, err := c.HGetAll(context.Background(), key).Result(); err != nil {
-		return nil
-	} else {
-		return v
-	}
+	return c.HGetAll(ctx, key).Result()
 }
 
 func (r *repo) sethash(key string, keyvals ...any) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
 
 	c := r.R()
 	if c == nil {
 		return errors.New("redis is nil. How?")
 	}
 
-	if err := c.HSet(context.Background(), key, keyvals...).Err(); err != nil {
+	if err := c.HSet(ctx, key, keyvals...).Err(); err != nil {
 		return err
 	}
 
@@ -97,12 +199,15 @@ func (r *repo) sethash(key string, keyvals ...any) error {
 }
 
 func (r *repo) pushzset(key, id string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
 		return errors.New("redis is nil. How?")
 	}
 
-	if err := c.ZAdd(context.Background(), key, &redis.Z{Member: id, Score: 9}).Err(); err != nil {
+	if err := c.ZAdd(ctx, key, &redis.Z{Member: id, Score: 9}).Err(); err != nil {
 		return err
 	}
 
@@ -110,24 +215,29 @@ func (r *repo) pushzset(key, id string) error {
 }
 
 func (r *repo) rmzset(key string, id string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if r == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	_, err := c.ZRem(context.Background(), key, id).Result()
+	_, err := c.ZRem(ctx, key, id).Result()
 
 	return err
 }
 
 func (r *repo) popzset(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.optimeout+r.timeout)
+	defer cancel()
+
 	c := r.R()
 	if r == nil {
-		fmt.Println("Error in R", r)
 		return "", errors.New("connection to redis is nil: how?")
 	}
 
-	z, err := c.BZPopMin(context.Background(), r.timeout, key).Result()
+	z, err := c.BZPopMin(ctx, r.timeout, key).Result()
 	if err != nil {
 		return "", err
 	}
@@ -136,15 +246,17 @@ func (r *repo) popzset(key string) (string, error) {
 }
 
 func (r *repo) ensurechannelstatus(channel string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if r == nil {
-		fmt.Println("Error in R", r)
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	exists, err := c.Exists(context.Background(), channelStatusKey(channel)).Result()
+	exists, err := c.Exists(ctx, r.keys.status(channel)).Result()
 	if err != nil {
-		fmt.Println("call to exists", err)
+		logger().Error("unable to read channel status", "channel", channel, "error", err)
 		return err
 	}
 
@@ -154,123 +266,792 @@ func (r *repo) ensurechannelstatus(channel string) error {
 		return nil
 	}
 
-	return c.HSet(context.Background(), channelStatusKey(channel), "name", channel, "appended", "0", "routed", "0", "created", fmt.Sprint(time.Now().Unix()), "is_paused", "false").Err()
+	return c.HSet(ctx, r.keys.status(channel), "name", channel, "appended", "0", "routed", "0", "created", fmt.Sprint(time.Now().Unix()), "is_paused", "false").Err()
 }
 
 func (r *repo) tranx(fn func(redis.Pipeliner) error) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
 	pipe := c.Pipeline()
-	defer pipe.Exec(context.Background())
+	defer pipe.Exec(ctx)
 
 	if err := fn(pipe); err != nil {
 		return err
 	}
 
-	_, err := pipe.Exec(context.Background())
+	_, err := pipe.Exec(ctx)
 
 	return err
 }
 
-func (r *repo) popfromchannel(channel, workingset string, count int) ([]string, error) {
-	channelstatus := r.loadobjectfromhash(channelStatusKey(channel))
-	if channelstatus == nil {
-		return nil, errors.New("channel does not exist")
+// setchannelpaused also registers channel in sq_channels, so a channel paused
+// before its first job is listed and can be resumed from the dashboard.
+func (r *repo) setchannelpaused(channel, reason, by string, resumeat time.Time) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	var resume int64
+	if !resumeat.IsZero() {
+		resume = resumeat.Unix()
+	}
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.keys.status(channel),
+			"name", channel,
+			"is_paused", "true",
+			"paused_reason", reason,
+			"paused_by", by,
+			"paused_at", fmt.Sprint(time.Now().Unix()),
+			"resume_at", fmt.Sprint(resume),
+		)
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9})
+		return nil
+	})
+}
+
+func (r *repo) setchannelresumed(channel string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.keys.status(channel), "name", channel, "is_paused", "false", "is_draining", "false")
+		pipe.HDel(ctx, r.keys.status(channel), "paused_reason", "paused_by", "paused_at", "resume_at")
+		return nil
+	})
+}
+
+func (r *repo) setchanneldraining(channel string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.keys.status(channel), "name", channel, "is_draining", "true")
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9})
+		return nil
+	})
+}
+
+func (r *repo) isdraining(channel string) (bool, error) {
+	v, err := r.hget(r.keys.status(channel), "is_draining")
+	return v == "true", err
+}
+
+// pendingcount returns the number of jobs waiting or scheduled in channel
+// plus the ones in its working set.
+func (r *repo) pendingcount(channel string) (int64, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return 0, errors.New("connection to redis is nil: how?")
+	}
+
+	pipe := c.Pipeline()
+	waiting := pipe.ZCard(ctx, r.keys.channel(channel))
+	scheduled := pipe.ZCard(ctx, r.keys.scheduled(channel))
+	inflight := pipe.LLen(ctx, r.keys.workingset(channel))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
 	}
 
-	ispaused, ok := channelstatus["is_paused"]
-	if !ok {
-		return nil, errors.New("channel status is missing")
+	return waiting.Val() + scheduled.Val() + inflight.Val(), nil
+}
+
+func (r *repo) purgechannel(channel string, deletejobs bool) (int, error) {
+	c := r.R()
+	if c == nil {
+		return 0, errors.New("connection to redis is nil: how?")
 	}
 
-	if ispaused == "true" {
-		return nil, ErrChannelPaused
+	var removed int
+	for _, key := range []string{r.keys.channel(channel), r.keys.scheduled(channel)} {
+		n, err := r.purgezset(key, channel, deletejobs)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
 	}
 
+	return removed, nil
+}
+
+func (r *repo) purgezset(key, channel string, deletejobs bool) (int, error) {
 	c := r.R()
 	if c == nil {
-		return nil, errors.New("connection to redis is nil: how?")
+		return 0, errors.New("connection to redis is nil: how?")
+	}
+
+	var removed int
+	for {
+		ctx, cancel := r.opcontext()
+		items, err := c.ZPopMin(ctx, key, 500).Result()
+		cancel()
+		if err != nil {
+			return removed, err
+		}
+
+		if len(items) == 0 {
+			return removed, nil
+		}
+
+		removed += len(items)
+
+		if !deletejobs {
+			continue
+		}
+
+		ids := make([]string, len(items))
+		for x, item := range items {
+			ids[x] = item.Member.(string)
+		}
+
+		if err := r.deletejobs(ids); err != nil {
+			return removed, err
+		}
+
+		countjobs(metricDeleted, channel, int64(len(ids)))
+	}
+}
+
+func (r *repo) deletechannel(channel string, deletejobs bool) (int, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	removed, err := r.purgechannel(channel, deletejobs)
+	if err != nil {
+		return removed, err
+	}
+
+	c := r.R()
+	if c == nil {
+		return removed, errors.New("connection to redis is nil: how?")
+	}
+
+	inflight, err := c.LRange(ctx, r.keys.workingset(channel), 0, -1).Result()
+	if err != nil {
+		return removed, err
+	}
+
+	removed += len(inflight)
+
+	if deletejobs && len(inflight) > 0 {
+		if err := r.deletejobs(inflight); err != nil {
+			return removed, err
+		}
 	}
 
-	//TODO: Pull items from workingset first - in case process is crashed and now resumes
+	return removed, r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, r.keys.channel(channel), r.keys.scheduled(channel), r.keys.status(channel), r.keys.workingset(channel), r.keys.latency(channel))
+		pipe.ZRem(ctx, r.keys.channels(), channel)
+		return nil
+	})
+}
+
+// popfromchannel moves up to count jobs of channel to its working set and to
+// the list of jobs taken by watch.
+func (r *repo) popfromchannel(channel, watch string, count int) ([]string, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
 
-	items, err := c.ZPopMin(context.Background(), channelKey(channel), int64(count)).Result()
+	c := r.R()
+	if c == nil {
+		return nil, errors.New("connection to redis is nil: how?")
+	}
+
+	channelstatus, err := c.HGetAll(ctx, r.keys.status(channel)).Result()
 	if err != nil {
-		return nil, errors.New("no items in channel")
+		return nil, err
+	}
+
+	//status created by the enqueue counters alone has no is_paused yet
+	if channelstatus["is_paused"] == "true" {
+		resumeat, _ := strconv.ParseInt(channelstatus["resume_at"], 10, 64)
+		if resumeat == 0 || time.Now().Unix() < resumeat {
+			if reason := channelstatus["paused_reason"]; len(reason) > 0 {
+				return nil, fmt.Errorf("%w: %s", ErrChannelPaused, reason)
+			}
+			return nil, ErrChannelPaused
+		}
+
+		if err := r.setchannelresumed(channel); err != nil {
+			return nil, err
+		}
 	}
 
-	var ids []string
-	for _, item := range items {
-		id := item.Member.(string)
-		c.RPush(context.Background(), workingset, id)
-		ids = append(ids, id)
+	if err := promotescript.Run(ctx, c, []string{r.keys.scheduled(channel), r.keys.channel(channel)}, time.Now().UnixMilli(), count).Err(); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	ids, err := popscript.Run(ctx, c, []string{r.keys.channel(channel), r.keys.workingset(channel), r.keys.watcherset(channel, watch)}, count).StringSlice()
+	if err != nil && err != redis.Nil {
+		return nil, err
 	}
 
 	return ids, nil
 }
 
-func (r *repo) addtochannel(id, channel string) error {
+// popscript moves up to ARGV[1] jobs from the channel to its working set and
+// the watcher's list in one step, so a failover between them cannot lose a job.
+var popscript = redis.NewScript(`
+local items = redis.call('ZPOPMIN', KEYS[1], ARGV[1])
+local ids = {}
+for i = 1, #items, 2 do
+	redis.call('RPUSH', KEYS[2], items[i])
+	redis.call('RPUSH', KEYS[3], items[i])
+	ids[#ids + 1] = items[i]
+end
+return ids
+`)
+
+// recoverscript puts the jobs a watcher left in the working set back on the
+// channel. Jobs an admin already requeued, moved or deleted are skipped.
+var recoverscript = redis.NewScript(`
+local ids = redis.call('LRANGE', KEYS[3], 0, -1)
+local recovered = 0
+for _, id in ipairs(ids) do
+	if redis.call('LREM', KEYS[2], 1, id) > 0 then
+		redis.call('ZADD', KEYS[1], 9, id)
+		recovered = recovered + 1
+	end
+end
+redis.call('DEL', KEYS[3])
+return recovered
+`)
+
+// recoverwatcher requeues the jobs watch took from channel and never finished,
+// e.g. because its process died.
+func (r *repo) recoverwatcher(channel, watch string) (int, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return 0, errors.New("connection to redis is nil: how?")
+	}
+
+	return recoverscript.Run(ctx, c, []string{r.keys.channel(channel), r.keys.workingset(channel), r.keys.watcherset(channel, watch)}).Int()
+}
+
+// unlistworking removes a finished job from the working set of channel and
+// the list of watch.
+func (r *repo) unlistworking(channel, watch, id string) error {
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.LRem(context.Background(), r.keys.workingset(channel), 0, id)
+		pipe.LRem(context.Background(), r.keys.watcherset(channel, watch), 0, id)
+		return nil
+	})
+}
+
+func (r *repo) addtochannel(id, channel string, keyvals ...any) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
 
 	c := r.R()
 	if c == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	channelkey := channelKey(channel)
-	jobkey := jobKey(id)
+	if draining, err := r.isdraining(channel); err != nil {
+		return err
+	} else if draining {
+		return ErrChannelDraining
+	}
+
+	channelkey := r.keys.channel(channel)
+	jobkey := r.keys.job(id)
 
-	r.tranx(func(pipe redis.Pipeliner) error {
-		pipe.HSet(context.Background(), jobkey, "id", id, "channel", channel, "created", fmt.Sprint(time.Now().Unix()))
-		pipe.ZAdd(context.Background(), channelkey, &redis.Z{Member: id, Score: 9})
-		pipe.ZAdd(context.Background(), channelsKey, &redis.Z{Member: channel, Score: 9})
-		pipe.HIncrBy(context.Background(), channelStatusKey(channel), "appended", 1)
+	keyvals = append(keyvals, "id", id, "channel", channel, "created", fmt.Sprint(time.Now().Unix()))
+	fields, err := jobfields(id, channel, keyvals...)
+	if err != nil {
+		return err
+	}
+
+	err = r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, jobkey, fields)
+		pipe.ZAdd(ctx, channelkey, &redis.Z{Member: id, Score: 9})
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9})
+		pipe.HIncrBy(ctx, r.keys.status(channel), "appended", 1)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	countjobs(metricEnqueued, channel, 1)
 
 	return nil
 }
 
-func (r *repo) deletejob(id string) error {
-	r.tranx(func(pipe redis.Pipeliner) error {
-		pipe.Del(context.Background(), jobKey(id))
-		pipe.RPush(context.Background(), storeKey, icc(id, "", "delete"))
+func (r *repo) addmanytochannel(channel string, jobs []BulkJob, chunksize int) ([]BulkResult, error) {
+	c := r.R()
+	if c == nil {
+		return nil, errors.New("connection to redis is nil: how?")
+	}
+
+	if draining, err := r.isdraining(channel); err != nil {
+		return nil, err
+	} else if draining {
+		return nil, ErrChannelDraining
+	}
+
+	if err := r.ensurechannelstatus(channel); err != nil {
+		return nil, err
+	}
+
+	results := make([]BulkResult, len(jobs))
+	channelkey := r.keys.channel(channel)
+
+	var appended int64
+	for start := 0; start < len(jobs); start += chunksize {
+		end := min(start+chunksize, len(jobs))
+		created := fmt.Sprint(time.Now().Unix())
+
+		ctx, cancel := r.opcontext()
+		pipe := c.Pipeline()
+		cmds := make(map[int][]redis.Cmder)
+
+		for x := start; x < end; x++ {
+			job := jobs[x]
+			results[x].ID = job.ID
+
+			if len(job.ID) == 0 {
+				results[x].Err = errors.New("id cannot be empty")
+				continue
+			}
+
+			var keyvals []any
+			for k, v := range job.Fields {
+				keyvals = append(keyvals, k, v)
+			}
+			keyvals = append(keyvals, "id", job.ID, "channel", channel, "created", created)
+
+			fields, err := jobfields(job.ID, channel, keyvals...)
+			if err != nil {
+				results[x].Err = err
+				continue
+			}
+
+			cmds[x] = []redis.Cmder{
+				pipe.HSet(ctx, r.keys.job(job.ID), fields),
+				pipe.ZAdd(ctx, channelkey, &redis.Z{Member: job.ID, Score: 9}),
+			}
+		}
+
+		//errors are checked per command below
+		pipe.Exec(ctx)
+		cancel()
+
+		for x, jobcmds := range cmds {
+			for _, cmd := range jobcmds {
+				if err := cmd.Err(); err != nil {
+					results[x].Err = err
+					break
+				}
+			}
+
+			if results[x].Err == nil {
+				appended++
+			}
+		}
+	}
+
+	if appended == 0 {
+		return results, nil
+	}
+
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	err := r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9})
+		pipe.HIncrBy(ctx, r.keys.status(channel), "appended", appended)
+		return nil
+	})
+
+	countjobs(metricEnqueued, channel, appended)
+
+	return results, err
+}
+
+// The unique enqueue runs in two scripts, since the job hash and the channel
+// keys hash to different cluster slots. claimjobscript creates the job hash
+// only if it does not exist yet.
+var claimjobscript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	return 0
+end
+
+redis.call('HSET', KEYS[1], 'id', ARGV[1], 'channel', ARGV[2], 'created', ARGV[3])
+return 1
+`)
+
+// adduniquescript queues a claimed job unless the channel is draining or,
+// with KEYS[3], another job holds the uniqueness key.
+var adduniquescript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'is_draining') == 'true' then
+	return {-1, ''}
+end
+
+if #KEYS > 2 then
+	local existing = redis.call('GET', KEYS[3])
+	if existing then
+		return {0, existing}
+	end
+	redis.call('SET', KEYS[3], ARGV[1], 'PX', ARGV[2])
+end
+
+redis.call('ZADD', KEYS[2], 9, ARGV[1])
+redis.call('HINCRBY', KEYS[1], 'appended', 1)
+return {1, ARGV[1]}
+`)
+
+func (r *repo) adduniquetochannel(id, channel, uniquekey string, window time.Duration) (EnqueueResult, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return EnqueueResult{}, errors.New("connection to redis is nil: how?")
+	}
+
+	if draining, err := r.isdraining(channel); err != nil {
+		return EnqueueResult{}, err
+	} else if draining {
+		return EnqueueResult{}, ErrChannelDraining
+	}
+
+	claimed, err := claimjobscript.Run(ctx, c, []string{r.keys.job(id)}, id, channel, fmt.Sprint(time.Now().Unix())).Int()
+	if err != nil {
+		return EnqueueResult{}, err
+	}
+
+	if claimed == 0 {
+		return EnqueueResult{ID: id}, nil
+	}
+
+	keys := []string{r.keys.status(channel), r.keys.channel(channel)}
+	if len(uniquekey) > 0 {
+		keys = append(keys, r.keys.unique(channel, uniquekey))
+	}
+
+	reply, err := adduniquescript.Run(ctx, c, keys, id, window.Milliseconds()).Slice()
+	if err == nil && len(reply) != 2 {
+		err = errors.New("unexpected reply from unique enqueue")
+	}
+
+	var created int64
+	var existing string
+	if err == nil {
+		created, _ = reply[0].(int64)
+		existing, _ = reply[1].(string)
+	}
+
+	if err != nil {
+		//the script may have run before the reply was lost; the claim is only
+		//released once the job is known not to be queued
+		if c.ZScore(ctx, r.keys.channel(channel), id).Err() == redis.Nil {
+			c.Del(ctx, r.keys.job(id))
+		}
+		return EnqueueResult{}, err
+	}
+
+	if created != 1 {
+		//release the claim, the job was not queued
+		c.Del(ctx, r.keys.job(id))
+	}
+
+	if created == -1 {
+		return EnqueueResult{}, ErrChannelDraining
+	}
+
+	if created == 0 {
+		return EnqueueResult{ID: existing}, nil
+	}
+
+	if err := c.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9}).Err(); err != nil {
+		return EnqueueResult{}, err
+	}
+
+	countjobs(metricEnqueued, channel, 1)
+
+	return EnqueueResult{ID: id, Created: true}, nil
+}
+
+// promotescript moves up to ARGV[2] scheduled jobs that are due by ARGV[1]
+// onto the channel.
+var promotescript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(due) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZADD', KEYS[2], 9, id)
+end
+return #due
+`)
+
+func (r *repo) scheduletochannel(id, channel string, at time.Time, keyvals ...any) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return errors.New("connection to redis is nil: how?")
+	}
+
+	if draining, err := r.isdraining(channel); err != nil {
+		return err
+	} else if draining {
+		return ErrChannelDraining
+	}
+
+	keyvals = append(keyvals, "id", id, "channel", channel, "created", fmt.Sprint(time.Now().Unix()))
+	fields, err := jobfields(id, channel, keyvals...)
+	if err != nil {
+		return err
+	}
+
+	err = r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.keys.job(id), fields)
+		pipe.ZAdd(ctx, r.keys.scheduled(channel), &redis.Z{Member: id, Score: float64(at.UnixMilli())})
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: channel, Score: 9})
+		pipe.HIncrBy(ctx, r.keys.status(channel), "appended", 1)
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	countjobs(metricEnqueued, channel, 1)
 
 	return nil
 }
 
+// canceljob takes a queued or scheduled job off channel and deletes it.
+func (r *repo) canceljob(id, channel string) (bool, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return false, errors.New("connection to redis is nil: how?")
+	}
+
+	pipe := c.TxPipeline()
+	queued := pipe.ZRem(ctx, r.keys.channel(channel), id)
+	scheduled := pipe.ZRem(ctx, r.keys.scheduled(channel), id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	if queued.Val()+scheduled.Val() == 0 {
+		return false, nil
+	}
+
+	if err := r.deletejob(id); err != nil {
+		return true, err
+	}
+
+	countjobs(metricDeleted, channel, 1)
+
+	return true, nil
+}
+
+func (r *repo) jobinfo(id string) (*JobInfo, error) {
+	obj, err := r.loadobjectfromhash(r.keys.job(id))
+	if err != nil {
+		return nil, err
+	}
+
+	job := Job(obj)
+	if len(job) == 0 {
+		return nil, ErrJobNotFound
+	}
+
+	state, err := r.jobstate(id, job.Channel())
+	if err != nil {
+		return nil, err
+	}
+
+	info := &JobInfo{
+		ID:      id,
+		Channel: job.Channel(),
+		State:   state,
+		Fields:  job,
+	}
+
+	if created, err := strconv.ParseInt(job["created"], 10, 64); err == nil {
+		info.Created = time.Unix(created, 0)
+	}
+
+	return info, nil
+}
+
+func (r *repo) deletejob(id string) error {
+	return r.deletejobs([]string{id})
+}
+
+const latencysamples = 1000
+
+// recordhandled updates the channel counters once a handler returned.
+func (r *repo) recordhandled(channel string, took time.Duration) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, r.keys.status(channel), "processed", 1)
+		pipe.LPush(ctx, r.keys.latency(channel), took.Milliseconds())
+		pipe.LTrim(ctx, r.keys.latency(channel), 0, latencysamples-1)
+		return nil
+	})
+}
+
+func (r *repo) recordfailed(channel string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return errors.New("connection to redis is nil: how?")
+	}
+
+	return c.HIncrBy(ctx, r.keys.status(channel), "failed", 1).Err()
+}
+
+func (r *repo) channelstats(channel string) (*ChannelStats, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return nil, errors.New("connection to redis is nil: how?")
+	}
+
+	pipe := c.Pipeline()
+	status := pipe.HGetAll(ctx, r.keys.status(channel))
+	depth := pipe.ZCard(ctx, r.keys.channel(channel))
+	scheduled := pipe.ZCard(ctx, r.keys.scheduled(channel))
+	inflight := pipe.LLen(ctx, r.keys.workingset(channel))
+	head := pipe.ZRange(ctx, r.keys.channel(channel), 0, 0)
+	latencies := pipe.LRange(ctx, r.keys.latency(channel), 0, -1)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	s := status.Val()
+	count := func(k string) int64 {
+		n, _ := strconv.ParseInt(s[k], 10, 64)
+		return n
+	}
+
+	stats := &ChannelStats{
+		Channel:   channel,
+		Depth:     depth.Val(),
+		Scheduled: scheduled.Val(),
+		InFlight:  inflight.Val(),
+		Appended:  count("appended"),
+		Routed:    count("routed"),
+		Processed: count("processed"),
+		Failed:    count("failed"),
+		Paused:    s["is_paused"] == "true",
+		Draining:  s["is_draining"] == "true",
+	}
+
+	if ids := head.Val(); len(ids) > 0 {
+		created, err := r.hget(r.keys.job(ids[0]), "created")
+		if err != nil {
+			return nil, err
+		}
+
+		if secs, err := strconv.ParseInt(created, 10, 64); err == nil {
+			stats.OldestAge = time.Since(time.Unix(secs, 0))
+		}
+	}
+
+	var samples []int64
+	for _, l := range latencies.Val() {
+		if ms, err := strconv.ParseInt(l, 10, 64); err == nil {
+			samples = append(samples, ms)
+		}
+	}
+
+	if len(samples) > 0 {
+		slices.Sort(samples)
+		percentile := func(p float64) time.Duration {
+			return time.Duration(samples[int(p*float64(len(samples)-1))]) * time.Millisecond
+		}
+
+		stats.LatencyP50 = percentile(0.50)
+		stats.LatencyP90 = percentile(0.90)
+		stats.LatencyP99 = percentile(0.99)
+	}
+
+	return stats, nil
+}
+
+// deletejobs removes the job hashes; the store deletes their copies and blobs.
+func (r *repo) deletejobs(ids []string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.Del(ctx, r.keys.job(id))
+			pipe.RPush(ctx, r.keys.store(), icc(id, "", "delete"))
+		}
+		return nil
+	})
+}
+
 func (r *repo) checkzhasmemeber(key, member string) bool {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
 		return false
 	}
 
-	return c.ZScore(context.Background(), key, member).Err() == nil
+	return c.ZScore(ctx, key, member).Err() == nil
 }
 
+// routetochannel ignores the draining state of channel: the job was accepted
+// upstream and refusing it would leave it stuck in the working set of its
+// current channel.
 func (r *repo) routetochannel(id, channel string, hasChanges bool) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	return r.tranx(func(pipe redis.Pipeliner) error {
+	err := r.tranx(func(pipe redis.Pipeliner) error {
 		if hasChanges {
 			//send to store
-			pipe.RPush(context.Background(), storeKey, icc(id, channel, "sync"))
+			pipe.RPush(ctx, r.keys.store(), icc(id, channel, "sync"))
 		} else {
 			//route job
-			pipe.ZAdd(context.Background(), channelKey(channel), &redis.Z{Member: id, Score: 9}).Err()
+			pipe.ZAdd(ctx, r.keys.channel(channel), &redis.Z{Member: id, Score: 9}).Err()
 		}
 
 		//set current job status to be in target channel
-		pipe.HIncrBy(context.Background(), channelStatusKey(channel), "routed", 1)
+		pipe.HIncrBy(ctx, r.keys.status(channel), "routed", 1)
 		return nil
 	})
+
+	//changed jobs are routed by the store once synced
+	if err == nil && !hasChanges {
+		countjobs(metricRouted, channel, 1)
+	}
+
+	return err
 }
 
 // func (r *repo) hinc(key, field string) error {
@@ -281,26 +1062,36 @@ func (r *repo) routetochannel(id, channel string, hasChanges bool) error {
 // 	}
 
 // 	// fmt.Println("adding 1 to ", field, "inside key", key)
-// 	return c.HIncrBy(context.Background(), key, field, 1).Err()
+// 	return c.HIncrBy(ctx, key, field, 1).Err()
 // }
 
-func (r *repo) hget(key, field string) string {
+// hget returns an empty value when key or field do not exist.
+func (r *repo) hget(key, field string) (string, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
-		fmt.Println("Error in R", r)
-		return ""
+		return "", errors.New("connection to redis is nil: how?")
 	}
 
-	return c.HGet(context.Background(), key, field).Val()
+	v, err := c.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return v, err
 }
 
 func (r *repo) deletekey(key string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if c == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	if err := c.Del(context.Background(), key).Err(); err != nil {
+	if err := c.Del(ctx, key).Err(); err != nil {
 		return err
 	}
 
@@ -308,12 +1099,15 @@ func (r *repo) deletekey(key string) error {
 }
 
 func (r *repo) deletelkey(key, id string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if r == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	if err := c.LRem(context.Background(), key, 0, id).Err(); err != nil {
+	if err := c.LRem(ctx, key, 0, id).Err(); err != nil {
 		return err
 	}
 
@@ -321,16 +1115,44 @@ func (r *repo) deletelkey(key, id string) error {
 }
 
 func (r *repo) pushlist(key, value string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	c := r.R()
 	if r == nil {
 		return errors.New("connection to redis is nil: how?")
 	}
 
-	err := c.RPush(context.Background(), key, value).Err()
+	err := c.RPush(ctx, key, value).Err()
 	return err
 }
 
+// restorelist moves everything left in workingset back to the head of key,
+// in its original order.
+func (r *repo) restorelist(key, workingset string) error {
+	c := r.R()
+	if c == nil {
+		return errors.New("connection to redis is nil: how?")
+	}
+
+	for {
+		ctx, cancel := r.opcontext()
+		err := c.LMove(ctx, workingset, key, "right", "left").Err()
+		cancel()
+		if err == redis.Nil {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
 func (r *repo) popfromlist(key, workingset string, count int) ([]string, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
 	var items []string
 	c := r.R()
 	if r == nil {
@@ -342,15 +1164,19 @@ func (r *repo) popfromlist(key, workingset string, count int) ([]string, error)
 		var item string
 
 		if len(workingset) > 0 {
-			item, err = c.LMove(context.Background(), key, workingset, "left", "right").Result()
+			item, err = c.LMove(ctx, key, workingset, "left", "right").Result()
 		} else {
-			item, err = c.LPop(context.Background(), key).Result()
+			item, err = c.LPop(ctx, key).Result()
 		}
 
-		if err != nil {
+		if err == redis.Nil {
 			return items, nil
 		}
 
+		if err != nil {
+			return items, err
+		}
+
 		if len(item) > 0 {
 			items = append(items, item)
 		}
@@ -384,7 +1210,9 @@ func (r *repo) _scan(key string, iszscan bool, fn func(string, string) error) er
 	var cursor uint64
 
 	for {
-		keys, c, err := method(context.Background(), key, cursor, "", 100).Result()
+		ctx, cancel := r.opcontext()
+		keys, c, err := method(ctx, key, cursor, "", 100).Result()
+		cancel()
 		if err != nil {
 			return err
 		}
@@ -394,13 +1222,11 @@ func (r *repo) _scan(key string, iszscan bool, fn func(string, string) error) er
 		var x = 0
 		var k, v string
 
-		for x < len(keys) {
+		//both scans reply with pairs: field/value or member/score
+		for x+1 < len(keys) {
 			k = keys[x]
-
-			if !iszscan {
-				v = keys[x+1]
-				x++
-			}
+			v = keys[x+1]
+			x++
 
 			if fn(k, v) != nil {
 				return nil
@@ -428,3 +1254,172 @@ func (r *repo) _scan(key string, iszscan bool, fn func(string, string) error) er
 		}
 	}
 }
+
+// zscanpage returns one page of members of the sorted set key and the cursor
+// for the next page; a zero cursor means the scan is complete.
+func (r *repo) zscanpage(key string, cursor uint64, count int64) ([]string, uint64, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return nil, 0, errors.New("redis is nil: how?")
+	}
+
+	pairs, next, err := c.ZScan(ctx, key, cursor, "", count).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var members []string
+	for x := 0; x+1 < len(pairs); x += 2 {
+		members = append(members, pairs[x])
+	}
+
+	return members, next, nil
+}
+
+func (r *repo) listrange(key string, start, count int64) ([]string, int64, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return nil, 0, errors.New("redis is nil: how?")
+	}
+
+	//a negative count reads to the end of the list
+	stop := start + count - 1
+	if count < 0 {
+		stop = -1
+	}
+
+	pipe := c.Pipeline()
+	items := pipe.LRange(ctx, key, start, stop)
+	total := pipe.LLen(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, 0, err
+	}
+
+	return items.Val(), total.Val(), nil
+}
+
+// jobstate reports where job id is: queued on its channel, in the channel's
+// working set, or neither.
+func (r *repo) jobstate(id, channel string) (string, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return "", errors.New("redis is nil: how?")
+	}
+
+	pipe := c.Pipeline()
+	queued := pipe.ZScore(ctx, r.keys.channel(channel), id)
+	scheduled := pipe.ZScore(ctx, r.keys.scheduled(channel), id)
+	working := pipe.LPos(ctx, r.keys.workingset(channel), id, redis.LPosArgs{})
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	if queued.Err() == nil {
+		return JobQueued, nil
+	}
+
+	if scheduled.Err() == nil {
+		return JobScheduled, nil
+	}
+
+	if working.Err() == nil {
+		return JobWorking, nil
+	}
+
+	return JobIdle, nil
+}
+
+// requeue moves ids from the working set of channel back onto the channel.
+func (r *repo) requeue(channel string, ids ...string) (int, error) {
+	c := r.R()
+	if c == nil {
+		return 0, errors.New("redis is nil: how?")
+	}
+
+	var moved int
+	for _, id := range ids {
+		ctx, cancel := r.opcontext()
+		removed, err := c.LRem(ctx, r.keys.workingset(channel), 1, id).Result()
+		if err == nil && removed > 0 {
+			err = c.ZAdd(ctx, r.keys.channel(channel), &redis.Z{Member: id, Score: 9}).Err()
+		}
+		cancel()
+
+		if err != nil {
+			return moved, err
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		moved++
+	}
+
+	return moved, nil
+}
+
+// movejob takes id off channel from (queue, schedule or working set) and
+// queues it on to. A scheduled job keeps its time on to.
+func (r *repo) movejob(id, from, to string) (bool, error) {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	c := r.R()
+	if c == nil {
+		return false, errors.New("redis is nil: how?")
+	}
+
+	pipe := c.Pipeline()
+	at := pipe.ZScore(ctx, r.keys.scheduled(from), id)
+	unqueued := pipe.ZRem(ctx, r.keys.channel(from), id)
+	unscheduled := pipe.ZRem(ctx, r.keys.scheduled(from), id)
+	unworked := pipe.LRem(ctx, r.keys.workingset(from), 1, id)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return false, err
+	}
+
+	if unqueued.Val() == 0 && unscheduled.Val() == 0 && unworked.Val() == 0 {
+		return false, nil
+	}
+
+	err := r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, r.keys.job(id), "channel", to)
+		if unscheduled.Val() > 0 {
+			pipe.ZAdd(ctx, r.keys.scheduled(to), &redis.Z{Member: id, Score: at.Val()})
+		} else {
+			pipe.ZAdd(ctx, r.keys.channel(to), &redis.Z{Member: id, Score: 9})
+		}
+		pipe.ZAdd(ctx, r.keys.channels(), &redis.Z{Member: to, Score: 9})
+		pipe.HIncrBy(ctx, r.keys.status(to), "routed", 1)
+		return nil
+	})
+
+	if err == nil {
+		countjobs(metricRouted, to, 1)
+	}
+
+	return true, err
+}
+
+// unlistjob removes id from the queue, schedule and working set of channel.
+func (r *repo) unlistjob(id, channel string) error {
+	ctx, cancel := r.opcontext()
+	defer cancel()
+
+	return r.tranx(func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, r.keys.channel(channel), id)
+		pipe.ZRem(ctx, r.keys.scheduled(channel), id)
+		pipe.LRem(ctx, r.keys.workingset(channel), 0, id)
+		return nil
+	})
+}
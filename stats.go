@@ -0,0 +1,29 @@
+package smartq
+
+import "time"
+
+// ChannelStats is a snapshot of a channel's queue and counters. Failed counts
+// handler results that could not be applied, OldestAge is the age of the job
+// at the head of the channel and latency percentiles are computed over the
+// most recent handler runs.
+type ChannelStats struct {
+	Channel    string        `json:"channel"`
+	Depth      int64         `json:"depth"`
+	Scheduled  int64         `json:"scheduled"`
+	InFlight   int64         `json:"inflight"`
+	Appended   int64         `json:"appended"`
+	Routed     int64         `json:"routed"`
+	Processed  int64         `json:"processed"`
+	Failed     int64         `json:"failed"`
+	Paused     bool          `json:"paused"`
+	Draining   bool          `json:"draining"`
+	OldestAge  time.Duration `json:"oldest_age"`
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP90 time.Duration `json:"latency_p90"`
+	LatencyP99 time.Duration `json:"latency_p99"`
+}
+
+// ChannelStats reads the statistics of channel in two round trips.
+func (a *Admin) ChannelStats(channel string) (*ChannelStats, error) {
+	return a.r.channelstats(channel)
+}
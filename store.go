@@ -1,12 +1,15 @@
 package smartq
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -16,10 +19,16 @@ import (
 
 type storeresponse map[string]interface{}
 
+var ErrStoreNotOpen = errors.New("store is not open: call Start first")
+
 type store struct {
 	filepath string
+	dbmu     sync.RWMutex
 	db       *bbolt.DB
 	port     int
+	r        *repo
+	token    string
+	auth     func(*http.Request) bool
 }
 
 func NewStore(filepath string, port int) *store {
@@ -29,10 +38,67 @@ func NewStore(filepath string, port int) *store {
 	}
 }
 
+// keys is the keyspace of the store's connection; before Start it has no
+// namespace.
+func (s *store) keys() keyspace {
+	if s.r == nil {
+		return keyspace{}
+	}
+	return s.r.keys
+}
+
+// NewStoreWithOptions is NewStore using opts instead of LoadConfig.
+func NewStoreWithOptions(filepath string, port int, opts Options) (*store, error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store{
+		filepath: filepath,
+		port:     port,
+		r:        r,
+	}, nil
+}
+
+// SetAuth makes the /store and /blob routes require the header
+// "Authorization: Bearer <token>" when token is set, and auth, when set, to
+// accept the request. Call it before Start; NewStoreClientWithToken sends the
+// token.
+func (s *store) SetAuth(token string, auth func(r *http.Request) bool) {
+	s.token = token
+	s.auth = auth
+}
+
+// authorize is the middleware of the protected routes.
+func (s *store) authorize(c *blueweb.Context) bool {
+	if !authorized(c.Request, s.token, s.auth) {
+		c.StatusWithString(http.StatusUnauthorized, "error: unauthorized")
+		return false
+	}
+	return true
+}
+
+// opendb returns the database once Start has opened it.
+func (s *store) opendb() (*bbolt.DB, error) {
+	s.dbmu.RLock()
+	defer s.dbmu.RUnlock()
+
+	if s.db == nil {
+		return nil, ErrStoreNotOpen
+	}
+	return s.db, nil
+}
+
 func (s *store) get(bucket, key string) (string, error) {
+	db, err := s.opendb()
+	if err != nil {
+		return "", err
+	}
+
 	var value string
 
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err = db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		if b == nil {
 			return errors.New("container: not found")
@@ -51,7 +117,12 @@ func (s *store) get(bucket, key string) (string, error) {
 }
 
 func (s *store) set(bucket, key, value string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	db, err := s.opendb()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
 		if err != nil {
 			return err
@@ -63,7 +134,12 @@ func (s *store) set(bucket, key, value string) error {
 }
 
 func (s *store) del(bucket, key string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	db, err := s.opendb()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
 		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
 		if err != nil {
 			return err
@@ -74,8 +150,13 @@ func (s *store) del(bucket, key string) error {
 }
 
 func (s *store) count(bucket, key string) (int, error) {
+	db, err := s.opendb()
+	if err != nil {
+		return 0, err
+	}
+
 	var count int
-	err := s.db.View(func(tx *bbolt.Tx) error {
+	err = db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(bucket))
 		if b == nil {
 			return errors.New("container: not found")
@@ -89,13 +170,59 @@ func (s *store) count(bucket, key string) (int, error) {
 }
 
 func (s *store) emptybucket(bucket string) error {
-	return s.db.Update(func(tx *bbolt.Tx) error {
+	db, err := s.opendb()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
 		return tx.DeleteBucket([]byte(bucket))
 	})
 }
 
+func (s *store) PutBlob(key string, data []byte) error {
+	return s.set(s.keys().blobbucket(), key, string(data))
+}
+
+func (s *store) GetBlob(key string) ([]byte, error) {
+	v, err := s.get(s.keys().blobbucket(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(v), nil
+}
+
+func (s *store) DeleteBlobs(prefix string) error {
+	db, err := s.opendb()
+	if err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(s.keys().blobbucket()))
+		if b == nil {
+			return nil
+		}
+
+		var keys [][]byte
+		cursor := b.Cursor()
+		for k, _ := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cursor.Next() {
+			keys = append(keys, k)
+		}
+
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
 func (s *store) printcurrentjobsanddata(bucket string) error {
-	fmt.Println("printing current list")
+	logger().Debug("printing store bucket", "bucket", bucket)
 	return s.db.View(func(tx *bbolt.Tx) error {
 		bucket := tx.Bucket([]byte(bucket))
 		if bucket == nil {
@@ -104,106 +231,189 @@ func (s *store) printcurrentjobsanddata(bucket string) error {
 
 		cursor := bucket.Cursor()
 		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
-			fmt.Printf("Key: %s, Value: %s\n", k, v)
+			value, err := decompressfield(string(v))
+			if err != nil {
+				value = string(v)
+			}
+			fmt.Printf("Key: %s, Value: %s\n", k, value)
 		}
 
-		fmt.Println("Done printing")
 		return nil
 	})
 }
 
-func (s *store) Start() {
+// handlecommand runs one command of the store list. Redis errors are
+// returned so the command is kept and retried.
+func (s *store) handlecommand(r *repo, message string) error {
+	id, channel, command := iccparse(message)
+
+	//system commands
+	if command == storeEmptyCommand {
+		if err := s.emptybucket(s.keys().bucket()); err != nil {
+			logger().Error("unable to empty store bucket", "bucket", s.keys().bucket(), "error", err)
+		} else {
+			logger().Info("emptied store bucket", "bucket", s.keys().bucket())
+		}
+		return nil
+	}
+
+	if command == "scan" {
+		return r.hscan("__test__hash__", func(k, v string) error {
+			logger().Debug("scan", "key", k, "value", v)
+			return nil
+		})
+	}
+
+	if command == storePrintCommand {
+		s.printcurrentjobsanddata(s.keys().bucket())
+		return nil
+	}
+
+	//TODO: at this point check if queue is paused
+
+	//channel commands
+	if command == deletecommand {
+		logger().Debug("store delete", "job_id", id)
+		s.del(s.keys().bucket(), id)
+		if err := s.DeleteBlobs(blobKey(id, "")); err != nil {
+			logger().Error("unable to delete job blobs", "job_id", id, "error", err)
+		}
+
+		//offloaded to a blob store other than this one
+		if bs, _ := blobstore(); bs != nil && bs != BlobStore(s) {
+			if err := bs.DeleteBlobs(blobKey(id, "")); err != nil {
+				logger().Error("unable to delete job blobs", "job_id", id, "error", err)
+			}
+		}
+		return nil
+	}
+
+	jobkey := r.keys.job(id)
+
+	if command == synccommand {
+		if err := r.sethash(jobkey, "channel", channel); err != nil {
+			return err
+		}
+
+		obj, err := r.loadobjectfromhash(jobkey)
+		if err != nil {
+			return err
+		}
+
+		if len(obj) > 0 {
+			jsoned, _ := json.Marshal(obj)
+			snapshot, err := compressfield(string(jsoned))
+			if err != nil {
+				logger().Error("unable to compress snapshot", "job_id", id, "error", err)
+				snapshot = string(jsoned)
+			}
+			s.set(s.keys().bucket(), id, snapshot)
+		}
+	}
+
+	logger().Debug("store route", "job_id", id, "channel", channel)
+	return r.routetochannel(id, channel, false)
+}
+
+// Start runs the store loop and, when a port is set, its web server. It
+// returns when the store cannot be opened or the server stops.
+func (s *store) Start() error {
+	if s.r == nil {
+		if !optionsset {
+			if err := LoadConfig(); err != nil {
+				return err
+			}
+		}
+		s.r = newrepo()
+	}
+
 	db, err := bbolt.Open(s.filepath, 0664, nil)
 	if err != nil {
-		panic(err)
+		return err
 	}
+	s.dbmu.Lock()
 	s.db = db
-
-	LoadConfig()
+	s.dbmu.Unlock()
 
 	ex := make(chan os.Signal, 2)
 	signal.Notify(ex, os.Interrupt, syscall.SIGTERM)
 	defer signal.Stop(ex)
 
 	go func(exitchannnel chan os.Signal) {
-		fmt.Println("inner loop started")
+		logger().Info("store loop started")
+
+		r := s.r
+		b := newbackoff()
 
-		r := newrepo()
-		defer r.Close()
+		//commands left over by a crash or a failed batch are handled first
+		restore := true
 
 		for {
 			select {
 			case <-exitchannnel:
-				fmt.Println("exiting inner loop")
+				logger().Info("store loop stopped")
 				return
 			default:
-				var messages []string
-				if messages, err = r.popfromlist(storeKey, "", 10); len(messages) == 0 {
-					time.Sleep(time.Millisecond * 250)
-					continue
-				}
-
-				for _, message := range messages {
-					id, channel, command := iccparse(message)
-
-					//system commands
-					if command == "empty" {
-						fmt.Println("emptying bucket")
-						fmt.Println("done", s.emptybucket(defautBucket))
+				if restore {
+					err := r.restorelist(r.keys.store(), r.keys.storeworking())
+					r.health.observe(err)
+					if err != nil {
+						logger().Warn("unable to restore store commands", "error", err)
+						b.wait()
 						continue
 					}
+					restore = false
+				}
 
-					if command == "scan" {
-						r.hscan("__test__hash__", func(k, v string) error {
-							fmt.Println("kv", k, v)
-							return nil
-						})
-					}
-
-					if command == storePrintCommand {
-						fmt.Println("print: " + id)
-						s.printcurrentjobsanddata(defautBucket)
-						continue
-					}
+				messages, err := r.popfromlist(r.keys.store(), r.keys.storeworking(), 10)
+				r.health.observe(err)
+				if err != nil && len(messages) == 0 {
+					logger().Warn("unable to read store commands", "error", err)
+					b.wait()
+					continue
+				}
 
-					//TODO: at this point check if queue is paused
+				if len(messages) == 0 {
+					b.reset()
+					time.Sleep(time.Millisecond * 250)
+					continue
+				}
 
-					//channel commands
-					if command == deletecommand {
-						fmt.Println("delete: " + id)
-						s.del(defautBucket, id)
-						continue
+				for _, message := range messages {
+					//a command stays in the working list until it is done
+					if err := s.handlecommand(r, message); err != nil {
+						logger().Warn("unable to handle store command, will retry", "command", message, "error", err)
+						r.health.observe(err)
+						restore = true
+						break
 					}
 
-					jobkey := jobKey(id)
-
-					if command == synccommand {
-						r.sethash(jobkey, "channel", channel)
-
-						obj := r.loadobjectfromhash(jobkey)
-						if len(obj) > 0 {
-							jsoned, _ := json.Marshal(obj)
-							s.set(defautBucket, id, string(jsoned))
-						}
+					if err := r.deletelkey(r.keys.storeworking(), message); err != nil {
+						logger().Warn("unable to remove handled store command", "command", message, "error", err)
 					}
+				}
 
-					fmt.Println("route: " + id)
-					r.routetochannel(id, channel, false)
+				if restore {
+					b.wait()
+					continue
 				}
+
+				b.reset()
 			}
 		}
 	}(ex)
 
 	if s.port == 0 {
-		fmt.Println("web is not started: waiting on exit channel")
+		logger().Info("store web server disabled, waiting for exit signal")
 		<-ex
-		fmt.Println("exiting: store")
+		logger().Info("store stopped")
 		os.Exit(0)
 	}
 
 	router := blueweb.NewRouter()
 
 	storeApi := router.Group("/store")
+	storeApi.Use(s.authorize)
 
 	storeApi.Get("/:key", func(c *blueweb.Context) {
 		key := c.Params("key")
@@ -212,11 +422,17 @@ func (s *store) Start() {
 			return
 		}
 
-		value, err := s.get(defautBucket, key)
+		value, err := s.get(s.keys().bucket(), key)
 		if err != nil {
 			c.Json(storeresponse{"error": "error: key not found"})
 			return
 		}
+
+		value, err = decompressfield(value)
+		if err != nil {
+			c.Json(storeresponse{"error": "error: unable to decompress value"})
+			return
+		}
 		if strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[") {
 			var i any
 			json.Unmarshal([]byte(value), &i)
@@ -236,7 +452,7 @@ func (s *store) Start() {
 			return
 		}
 
-		err := s.set(defautBucket, key, value)
+		err := s.set(s.keys().bucket(), key, value)
 		if err != nil {
 			c.Json(storeresponse{"error": "error: error adding value"})
 			return
@@ -252,7 +468,7 @@ func (s *store) Start() {
 			return
 		}
 
-		err := s.del(defautBucket, key)
+		err := s.del(s.keys().bucket(), key)
 		if err != nil {
 			c.Json(storeresponse{"error": "error: key not foun or error deleting key"})
 			return
@@ -261,7 +477,61 @@ func (s *store) Start() {
 		c.Json(storeresponse{"success": true})
 	})
 
+	metrics := metricshandler(s.r)
+	router.Get("/metrics", func(c *blueweb.Context) {
+		metrics.ServeHTTP(c.ResponseWriter, c.Request)
+	})
+
+	blobApi := router.Group("/blob")
+	blobApi.Use(s.authorize)
+
+	blobApi.Get("", func(c *blueweb.Context) {
+		data, err := s.GetBlob(c.Query("key"))
+		if err != nil {
+			c.StatusWithString(http.StatusNotFound, err.Error())
+			return
+		}
+
+		c.Write(data)
+	})
+
+	blobApi.Put("", func(c *blueweb.Context) {
+		key := c.Query("key")
+		if key == "" {
+			c.StatusWithString(http.StatusBadRequest, "error: key not provided")
+			return
+		}
+
+		data, err := c.Body()
+		if err != nil {
+			c.StatusWithString(http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err := s.PutBlob(key, data); err != nil {
+			c.StatusWithString(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.String("ok")
+	})
+
+	blobApi.Delete("", func(c *blueweb.Context) {
+		prefix := c.Query("prefix")
+		if prefix == "" {
+			c.StatusWithString(http.StatusBadRequest, "error: prefix not provided")
+			return
+		}
+
+		if err := s.DeleteBlobs(prefix); err != nil {
+			c.StatusWithString(http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		c.String("ok")
+	})
+
 	router.Config().SetDev(true).SetPort(s.port).StopOnInterrupt()
 
-	router.StartServer()
+	return router.StartServer()
 }
@@ -0,0 +1,114 @@
+package smartq
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace context travels with the job in reserved fields, in W3C trace context
+// format. Spans are created with the global OpenTelemetry tracer provider, so
+// nothing is recorded unless the application installs one.
+
+const tracerName = "github.com/sfi2k7/smartq"
+
+const traceparentfield = "_traceparent"
+const tracestatefield = "_tracestate"
+
+const (
+	outcomeDone    = "done"
+	outcomeFailed  = "failed"
+	outcomeDeleted = "deleted"
+	outcomeRouted  = "routed"
+)
+
+var tracepropagator = propagation.TraceContext{}
+
+// jobcarrier maps trace context keys onto reserved job fields.
+type jobcarrier map[string]string
+
+func (jc jobcarrier) Get(key string) string {
+	return jc["_"+key]
+}
+
+func (jc jobcarrier) Set(key, value string) {
+	jc["_"+key] = value
+}
+
+func (jc jobcarrier) Keys() []string {
+	var keys []string
+	for _, k := range []string{traceparentfield, tracestatefield} {
+		if _, ok := jc[k]; ok {
+			keys = append(keys, k[1:])
+		}
+	}
+	return keys
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// tracefields returns the trace context of ctx as job key/value pairs, or
+// nothing when ctx carries no span.
+func tracefields(ctx context.Context) []any {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+
+	carrier := jobcarrier{}
+	tracepropagator.Inject(ctx, carrier)
+
+	var keyvals []any
+	for _, k := range []string{traceparentfield, tracestatefield} {
+		if v, ok := carrier[k]; ok && len(v) > 0 {
+			keyvals = append(keyvals, k, v)
+		}
+	}
+	return keyvals
+}
+
+func startenqueuespan(ctx context.Context, channel, id string) (context.Context, trace.Span) {
+	return tracer().Start(ctx, "smartq.enqueue "+channel,
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "smartq"),
+			attribute.String("smartq.channel", channel),
+			attribute.String("smartq.job_id", id),
+		),
+	)
+}
+
+// startprocessspan continues the trace stored on job, so every hop of a job
+// ends up in the producer's trace.
+func startprocessspan(watch, channel string, job Job) (context.Context, trace.Span) {
+	parent := tracepropagator.Extract(context.Background(), jobcarrier(job))
+
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "smartq"),
+			attribute.String("smartq.channel", channel),
+			attribute.String("smartq.job_id", job.ID()),
+			attribute.String("smartq.watch", watch),
+		),
+	}
+
+	if producer := trace.SpanContextFromContext(parent); producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+
+	return tracer().Start(parent, "smartq.process "+channel, opts...)
+}
+
+func endspan(span trace.Span, outcome string) {
+	span.SetAttributes(attribute.String("smartq.outcome", outcome))
+	if outcome == outcomeFailed {
+		span.SetStatus(codes.Error, "job failed")
+	}
+	span.End()
+}
@@ -0,0 +1,146 @@
+package smartq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// TypedWatch wraps Watch and hands callbacks a job decoded into T.
+// T must be a struct type using the tags understood by Job.Decode.
+type TypedWatch[T any] struct {
+	w *Watch
+}
+
+type TypedContext[T any] struct {
+	*WatchContext
+	Data T
+	// Err is set when the job could not be decoded into Data.
+	Err error
+}
+
+func NewTypedWatch[T any](name string) *TypedWatch[T] {
+	return &TypedWatch[T]{
+		w: NewWatch(name),
+	}
+}
+
+// NewTypedWatchWithOptions is NewTypedWatch using opts instead of LoadConfig.
+func NewTypedWatchWithOptions[T any](name string, opts Options) (*TypedWatch[T], error) {
+	w, err := NewWatchWithOptions(name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TypedWatch[T]{
+		w: w,
+	}, nil
+}
+
+func (tw *TypedWatch[T]) Close() error {
+	return tw.w.Close()
+}
+
+func (tw *TypedWatch[T]) Name() string {
+	return tw.w.Name()
+}
+
+func (tw *TypedWatch[T]) Start(channel string, callback func(*TypedContext[T]) *RouteToken) {
+	tw.w.Start(channel, func(wc *WatchContext) *RouteToken {
+		ctx := &TypedContext[T]{
+			WatchContext: wc,
+		}
+
+		ctx.Err = wc.Job.Decode(&ctx.Data)
+
+		return callback(ctx)
+	})
+}
+
+// Route sends the job to channel, persisting only the fields of v that differ
+// from the job as it was loaded. If v cannot be encoded the job is not routed
+// and stays in the working set.
+func (tc *TypedContext[T]) Route(channel string, v T) *RouteToken {
+	keyvals, err := Encode(v)
+	if err != nil {
+		return tc.w.failedtoken(fmt.Errorf("encode job for %s: %w", channel, err))
+	}
+
+	var changed []any
+	for x := 1; x < len(keyvals); x += 2 {
+		k := keyvals[x-1].(string)
+		if keyvals[x].(string) != tc.Job.String(k) {
+			changed = append(changed, k, keyvals[x])
+		}
+	}
+
+	return tc.WatchContext.Route(channel, changed...)
+}
+
+// Producer enqueues jobs on a single channel from values of T.
+type Producer[T any] struct {
+	channel string
+	r       *repo
+}
+
+func NewProducer[T any](channel string) *Producer[T] {
+	return &Producer[T]{
+		channel: channel,
+	}
+}
+
+// NewProducerWithOptions is NewProducer with its own connection made from opts.
+func NewProducerWithOptions[T any](channel string, opts Options) (*Producer[T], error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Producer[T]{
+		channel: channel,
+		r:       r,
+	}, nil
+}
+
+func (p *Producer[T]) repo() *repo {
+	if p.r == nil {
+		return getcachedrepo()
+	}
+	return p.r
+}
+
+func (p *Producer[T]) Channel() string {
+	return p.channel
+}
+
+func (p *Producer[T]) Enqueue(id string, v T) error {
+	keyvals, err := Encode(v)
+	if err != nil {
+		return err
+	}
+
+	return enqueuejob(context.Background(), p.repo(), p.channel, id, keyvals...)
+}
+
+func (p *Producer[T]) EnqueueMany(ids []string, vs []T) ([]BulkResult, error) {
+	if len(ids) != len(vs) {
+		return nil, errors.New("ids and values must have the same length")
+	}
+
+	jobs := make([]BulkJob, len(ids))
+	for x := range ids {
+		keyvals, err := Encode(vs[x])
+		if err != nil {
+			return nil, err
+		}
+
+		fields := make(map[string]any, len(keyvals)/2)
+		for y := 1; y < len(keyvals); y += 2 {
+			fields[keyvals[y-1].(string)] = keyvals[y]
+		}
+
+		jobs[x] = BulkJob{ID: ids[x], Fields: fields}
+	}
+
+	return initjobs(p.repo(), p.channel, jobs)
+}
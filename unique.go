@@ -0,0 +1,55 @@
+package smartq
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrJobExists = errors.New("job already exists")
+
+type UniqueOptions struct {
+	// Key dedupes jobs on the channel for Window; when empty only the job id is checked.
+	Key    string
+	Window time.Duration
+	// Reject makes InitUniqueJob return ErrJobExists instead of a silent no-op.
+	Reject bool
+}
+
+type EnqueueResult struct {
+	// ID is the new job, or the existing job that caused the no-op.
+	ID      string
+	Created bool
+}
+
+// InitUniqueJob enqueues a job only if sq_job_<id> does not exist yet and, when
+// opts.Key is set, no other job claimed the same key on channel within opts.Window.
+// Like InitJob it uses the connection of SetOptions or LoadConfig; see
+// Client.EnqueueUnique.
+func InitUniqueJob(channel, id string, opts UniqueOptions) (EnqueueResult, error) {
+	return enqueueunique(getcachedrepo(), channel, id, opts)
+}
+
+func enqueueunique(r *repo, channel, id string, opts UniqueOptions) (EnqueueResult, error) {
+	if len(id) == 0 {
+		return EnqueueResult{}, errors.New("id cannot be empty")
+	}
+
+	if err := checkchannel(channel); err != nil {
+		return EnqueueResult{}, err
+	}
+
+	if len(opts.Key) > 0 && opts.Window <= 0 {
+		return EnqueueResult{}, errors.New("window must be set when using a uniqueness key")
+	}
+
+	result, err := r.adduniquetochannel(id, channel, opts.Key, opts.Window)
+	if err != nil {
+		return result, err
+	}
+
+	if !result.Created && opts.Reject {
+		return result, ErrJobExists
+	}
+
+	return result, nil
+}
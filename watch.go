@@ -1,17 +1,33 @@
 package smartq
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type RouteToken struct {
 	cmd   string
 	token string
+	// err is set when the job could not be prepared for its next hop; the
+	// job is then left in the working set instead of routed.
+	err error
+}
+
+// failedtoken leaves the job in the working set, to be requeued when the
+// watcher starts again.
+func (w *Watch) failedtoken(err error) *RouteToken {
+	return &RouteToken{
+		token: w.ctxtoken,
+		err:   err,
+	}
 }
 
 type Watch struct {
@@ -20,6 +36,10 @@ type Watch struct {
 	ctxtoken string
 }
 
+// NewWatch returns a watcher named name. Jobs a watcher took but never
+// finished, e.g. because its process died, are requeued when a watcher with
+// the same name starts on the channel, so watchers running at the same time
+// need distinct names.
 func NewWatch(name string) *Watch {
 	return &Watch{
 		name:     name,
@@ -28,6 +48,20 @@ func NewWatch(name string) *Watch {
 	}
 }
 
+// NewWatchWithOptions is NewWatch using opts instead of LoadConfig.
+func NewWatchWithOptions(name string, opts Options) (*Watch, error) {
+	r, err := newrepowithoptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watch{
+		name:     name,
+		r:        r,
+		ctxtoken: ID(),
+	}, nil
+}
+
 func (w *Watch) Close() error {
 	return w.r.Close()
 }
@@ -36,39 +70,91 @@ func (w *Watch) Name() string {
 	return w.name
 }
 
-func InitJob(channel, id string) error {
+func (w *Watch) log() *slog.Logger {
+	return logger().With("watch", w.name)
+}
+
+// InitJob enqueues job id on channel. Optional key/value pairs (for example
+// the result of Encode) are stored on the job along with its metadata.
+// It uses the connection of SetOptions or LoadConfig; Client.Enqueue is the
+// same on a connection of your own.
+func InitJob(channel, id string, keyvals ...any) error {
+	return InitJobContext(context.Background(), channel, id, keyvals...)
+}
+
+// InitJobContext is InitJob that also stores the trace context of ctx on the
+// job, so the watchers handling it join the caller's trace.
+func InitJobContext(ctx context.Context, channel, id string, keyvals ...any) error {
+	return enqueuejob(ctx, getcachedrepo(), channel, id, keyvals...)
+}
+
+func enqueuejob(ctx context.Context, r *repo, channel, id string, keyvals ...any) error {
 	if len(id) == 0 {
 		return errors.New("id cannot be empty")
 	}
 
-	if len(channel) == 0 {
-		return errors.New("channel cannot be empty")
+	if err := checkchannel(channel); err != nil {
+		return err
 	}
 
-	r := getcachedrepo()
+	if len(keyvals)%2 != 0 {
+		return errors.New("keyvals must be key/value pairs")
+	}
+
+	ctx, span := startenqueuespan(ctx, channel, id)
+	defer span.End()
+
+	keyvals = append(keyvals, tracefields(ctx)...)
+
+	err := r.addtochannel(id, channel, keyvals...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
 
-	return r.addtochannel(id, channel)
+	return err
 }
 
 func (w *Watch) Start(channel string, callback func(*WatchContext) *RouteToken) {
+	if err := checkchannel(channel); err != nil {
+		w.log().Error("unable to watch channel", "channel", channel, "error", err)
+		return
+	}
+
 	defer func() {
-		fmt.Println(w.name, "stopped watching", channel)
+		w.log().Info("stopped watching", "channel", channel)
 	}()
 
 	ex := make(chan os.Signal, 2)
 	signal.Notify(ex, os.Interrupt, syscall.SIGTERM)
 	defer signal.Stop(ex)
 
-	w.r.pushzset("sq_watches", w.name+"|"+channel)
+	w.r.pushzset(w.r.keys.watches(), w.name+"|"+channel)
 
 	defer func() {
-		w.r.rmzset("sw_watches", w.name+"|"+channel)
+		w.r.rmzset(w.r.keys.watches(), w.name+"|"+channel)
 	}()
 
-	workingset := "workingset_" + channel
-
 	var err error
 	var ids []string
+	var paused bool
+
+	//redis errors back off, so a failover is ridden out instead of hammered
+	b := newbackoff()
+
+	//jobs this watcher took before it was stopped or crashed go back first
+	var recovered int
+	ok := w.persist(ex, b, "requeue unfinished jobs", "", func() (err error) {
+		recovered, err = w.r.recoverwatcher(channel, w.name)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	if recovered > 0 {
+		w.log().Info("requeued unfinished jobs", "channel", channel, "count", recovered)
+	}
 
 	for {
 
@@ -76,16 +162,31 @@ func (w *Watch) Start(channel string, callback func(*WatchContext) *RouteToken)
 		case <-ex:
 			return
 		default:
-			ids, err = w.r.popfromchannel(channel, workingset, 10)
+			ids, err = w.r.popfromchannel(channel, w.name, 10)
+			w.r.health.observe(err)
+			if err != nil && !errors.Is(err, ErrChannelPaused) {
+				w.log().Warn("unable to pop jobs", "channel", channel, "error", err)
+				b.wait()
+				continue
+			}
+
+			b.reset()
+
 			if err != nil {
-				if err == ErrChannelPaused {
-					// fmt.Println("channel is paused:", channel)
+				if !paused {
+					paused = true
+					w.log().Info("channel is paused", "channel", channel, "reason", err)
 				}
 
 				time.Sleep(time.Millisecond * 250)
 				continue
 			}
 
+			if paused {
+				paused = false
+				w.log().Info("channel is resumed", "channel", channel)
+			}
+
 			if len(ids) == 0 {
 				time.Sleep(time.Millisecond * 250)
 				continue
@@ -93,40 +194,77 @@ func (w *Watch) Start(channel string, callback func(*WatchContext) *RouteToken)
 
 			for _, id := range ids {
 
-				// fmt.Println(w.r.conn.LLen(context.Background(), workingset).Result())
-				// w.r.pushlist(workingset, id)
-
 				ctx := &WatchContext{
 					ID:      id,
 					Channel: channel,
 					w:       w,
 				}
 
-				var job = w.r.loadobjectfromhash(jobKey(id))
+				var job map[string]string
+				ok := w.persist(ex, b, "load job", id, func() (err error) {
+					job, err = w.r.loadobjectfromhash(w.r.keys.job(id))
+					return err
+				})
+				if !ok {
+					//still listed for this watcher, requeued on its next start
+					return
+				}
 
 				ctx.Job = job
 
+				var span trace.Span
+				ctx.ctx, span = startprocessspan(w.name, channel, job)
+
+				started := time.Now()
+
 				nextcommand := callback(ctx)
-				if nextcommand == nil {
-					w.r.deletelkey(workingset, id)
+				observehandler(w.name, ctx.Channel, time.Since(started))
+
+				if nextcommand != nil && nextcommand.err != nil && nextcommand.token == w.ctxtoken {
+					w.log().Error("unable to route job, leaving it in the working set", "job_id", id, "channel", ctx.Channel, "error", nextcommand.err)
+					w.r.recordfailed(ctx.Channel)
+					countjobs(metricFailed, ctx.Channel, 1)
+					endspan(span, outcomeFailed)
 					continue
 				}
 
-				if len(nextcommand.cmd) == 0 || nextcommand.token != w.ctxtoken {
-					w.r.deletelkey(workingset, id)
+				if nextcommand == nil || len(nextcommand.cmd) == 0 || nextcommand.token != w.ctxtoken {
+					w.r.recordhandled(ctx.Channel, time.Since(started))
+					w.unlist(channel, id)
+					endspan(span, outcomeDone)
 					continue
 				}
 
 				id, channel, command := iccparse(nextcommand.cmd)
 
-				w.r.deletelkey(workingset, id)
-
-				if command == deletecommand {
-					w.r.deletejob(id)
+				//the job leaves the working set only once it is safe elsewhere,
+				//so a failover in between cannot lose it
+				ok = w.persist(ex, b, "complete job", id, func() error {
+					switch command {
+					case deletecommand:
+						return w.r.deletejob(id)
+					case routecommand:
+						return w.r.routetochannel(id, channel, ctx.haschanged)
+					}
+					return nil
+				})
+				if !ok {
+					endspan(span, outcomeFailed)
+					return
 				}
 
-				if command == routecommand {
-					w.r.routetochannel(id, channel, ctx.haschanged)
+				w.unlist(ctx.Channel, id)
+
+				w.r.recordhandled(ctx.Channel, time.Since(started))
+
+				switch command {
+				case deletecommand:
+					countjobs(metricDeleted, ctx.Channel, 1)
+					endspan(span, outcomeDeleted)
+				case routecommand:
+					endspan(span, outcomeRouted)
+				default:
+					endspan(span, outcomeDone)
 				}
 			}
 
@@ -134,3 +272,33 @@ func (w *Watch) Start(channel string, callback func(*WatchContext) *RouteToken)
 		}
 	}
 }
+
+// unlist removes a finished job from the working set. If that fails the job
+// stays listed and may be handled again after a requeue, but is never lost.
+func (w *Watch) unlist(channel, id string) {
+	if err := w.r.unlistworking(channel, w.name, id); err != nil {
+		w.log().Warn("unable to remove job from the working set", "job_id", id, "error", err)
+	}
+}
+
+// persist calls fn until it succeeds, backing off between attempts. It
+// returns false when the watcher is stopped first.
+func (w *Watch) persist(ex chan os.Signal, b *backoff, what, id string, fn func() error) bool {
+	for {
+		err := fn()
+		w.r.health.observe(err)
+		if err == nil {
+			b.reset()
+			return true
+		}
+
+		w.log().Warn("unable to "+what+", retrying", "job_id", id, "error", err)
+
+		select {
+		case <-ex:
+			return false
+		default:
+			b.wait()
+		}
+	}
+}
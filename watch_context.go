@@ -1,7 +1,7 @@
 package smartq
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 )
 
@@ -11,13 +11,43 @@ type WatchContext struct {
 	Job        Job
 	w          *Watch
 	haschanged bool
+	ctx        context.Context
 }
 
+// Context carries the span of the current handler run; use it to create
+// child spans or pass it to InitJobContext.
+func (wc *WatchContext) Context() context.Context {
+	if wc.ctx == nil {
+		return context.Background()
+	}
+	return wc.ctx
+}
+
+// propagate stores the handler span on the job so the next hop continues
+// the same trace. It does not count as a change to the job.
+func (wc *WatchContext) propagate() {
+	if fields := tracefields(wc.Context()); len(fields) > 0 {
+		wc.w.r.sethash(wc.w.r.keys.job(wc.ID), fields...)
+	}
+}
+
+// Route sends the job to channel after writing keyvals to it. If they cannot
+// be written the job is not routed and stays in the working set.
 func (wc *WatchContext) Route(channel string, keyvals ...any) *RouteToken {
+	if err := checkchannel(channel); err != nil {
+		return wc.w.failedtoken(err)
+	}
+
+	wc.propagate()
+
 	if len(keyvals) > 0 {
-		err := wc.w.r.sethash(jobKey(wc.ID), keyvalstostring(keyvals...))
+		fields, err := jobfields(wc.ID, channel, keyvals...)
 		if err != nil {
-			fmt.Println("error setting key/val on job using route")
+			return wc.w.failedtoken(fmt.Errorf("encode job fields for %s: %w", channel, err))
+		}
+
+		if err := wc.w.r.sethash(wc.w.r.keys.job(wc.ID), fields); err != nil {
+			return wc.w.failedtoken(fmt.Errorf("write job fields for %s: %w", channel, err))
 		}
 
 		wc.haschanged = true
@@ -36,20 +66,39 @@ func (wc *WatchContext) NoOp() *RouteToken {
 	}
 }
 
-func (w *WatchContext) SetKV(k string, v any) {
-	w.w.r.sethash(jobKey(w.ID), k, v)
+// SetKV writes field k of the job straight to redis.
+func (w *WatchContext) SetKV(k string, v any) error {
+	fields, err := jobfields(w.ID, w.Channel, k, v)
+	if err != nil {
+		return err
+	}
+
+	return w.w.r.sethash(w.w.r.keys.job(w.ID), fields)
 }
 
-func (w *WatchContext) SetObj(k string, o any) {
-	jsoned, _ := json.Marshal(o)
-	w.SetKV(k, string(jsoned))
+func (w *WatchContext) SetObj(k string, o any) error {
+	encoded, err := encodeobj(codecfor(w.Channel), o)
+	if err != nil {
+		return err
+	}
+
+	return w.SetKV(k, encoded)
 }
 
 func (w *WatchContext) GetObj(k string, o any) error {
-	jsoned := w.w.r.hget(jobKey(w.ID), k)
-	if jsoned == "" {
+	encoded, err := w.w.r.hget(w.w.r.keys.job(w.ID), k)
+	if err != nil {
+		return err
+	}
+
+	if encoded == "" {
 		return fmt.Errorf("key %s not found", k)
 	}
 
-	return json.Unmarshal([]byte(jsoned), o)
+	encoded, err = unpackfield(encoded)
+	if err != nil {
+		return err
+	}
+
+	return decodeobj(encoded, o)
 }